@@ -2,10 +2,12 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"scyd/config"
 	"strings"
 )
 
@@ -25,10 +27,56 @@ func readHost() string {
 	return string(data)
 }
 
+// A config problem reported by the test api
+type problem struct {
+	Section string
+	Key     string
+	Message string
+}
+
+type testResult struct {
+	Valid    bool
+	Problems []problem
+}
+
+type fieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+type sectionDiff struct {
+	Added   []string
+	Removed []string
+	Changed []struct {
+		Name   string
+		Fields []fieldChange
+	}
+}
+
+type reloadResult struct {
+	Applied  bool
+	Problems []problem
+	Diff     *struct {
+		Jobs      sectionDiff
+		Pools     sectionDiff
+		Notifiers sectionDiff
+	}
+	ApiJobProblems []problem
+}
+
 func doPut(host string, resource string, data string) []byte {
+	status, contents := doRequest("PUT", host, resource, data)
+	if status < 200 || status >= 300 {
+		err_exit(fmt.Sprintf("Command  %s failed: %d (%s)", resource, status, string(contents)))
+	}
+	return contents
+}
+
+func doRequest(method string, host string, resource string, data string) (int, []byte) {
 	client := &http.Client{}
 	url := fmt.Sprintf("http://%s/api/v1/%s", host, resource)
-	request, err := http.NewRequest("PUT", url, strings.NewReader(data))
+	request, err := http.NewRequest(method, url, strings.NewReader(data))
 	if err != nil {
 		err_exit(fmt.Sprintf("Request allocation for %s failed: %s", resource, err.Error()))
 	}
@@ -42,24 +90,89 @@ func doPut(host string, resource string, data string) []byte {
 	if err != nil {
 		err_exit(fmt.Sprintf("Request body read for %s failed: %s", resource, err.Error()))
 	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		body := ""
-		if contents != nil {
-			body = string(contents)
+	return response.StatusCode, contents
+}
+
+func test(host string) {
+	status, contents := doRequest("PUT", host, "test", "")
+	var result testResult
+	if err := json.Unmarshal(contents, &result); err != nil {
+		err_exit(fmt.Sprintf("Command test failed: %d (%s)", status, string(contents)))
+	}
+	if result.Valid {
+		fmt.Println("config ok")
+		return
+	}
+	printProblems(result.Problems)
+	err_exit(fmt.Sprintf("%d config problem(s)", len(result.Problems)))
+}
+
+func printProblems(problems []problem) {
+	for _, p := range problems {
+		switch {
+		case p.Section == "":
+			fmt.Println(p.Message)
+		case p.Key == "":
+			fmt.Printf("[%s]: %s\n", p.Section, p.Message)
+		default:
+			fmt.Printf("[%s] %s: %s\n", p.Section, p.Key, p.Message)
 		}
-		err_exit(fmt.Sprintf("Command  %s failed: %d (%s)", resource, response.StatusCode, body))
 	}
-	return contents
 }
 
-func test(host string) {
-	doPut(host, "test", "")
-	fmt.Println("config ok")
+// Print added (+), removed (-) and changed (~) sections. Returns false if there were none
+func printSectionDiff(kind string, diff sectionDiff) bool {
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		return false
+	}
+	fmt.Printf("%s:\n", kind)
+	for _, name := range diff.Added {
+		fmt.Printf("  + %s\n", name)
+	}
+	for _, name := range diff.Removed {
+		fmt.Printf("  - %s\n", name)
+	}
+	for _, change := range diff.Changed {
+		fmt.Printf("  ~ %s\n", change.Name)
+		for _, f := range change.Fields {
+			fmt.Printf("      %s: %q -> %q\n", f.Field, f.Old, f.New)
+		}
+	}
+	return true
 }
 
-func reload(host string) {
-	doPut(host, "reload", "")
-	fmt.Println("reloaded")
+func reload(host string, dry_run bool) {
+	resource := "reload"
+	if dry_run {
+		resource = "reload?dry_run=true"
+	}
+	status, contents := doRequest("PUT", host, resource, "")
+	var result reloadResult
+	if err := json.Unmarshal(contents, &result); err != nil {
+		err_exit(fmt.Sprintf("Command reload failed: %d (%s)", status, string(contents)))
+	}
+	if len(result.Problems) > 0 {
+		printProblems(result.Problems)
+		err_exit(fmt.Sprintf("%d config problem(s). Not reloaded", len(result.Problems)))
+	}
+	changed := false
+	if d := result.Diff; d != nil {
+		changed = printSectionDiff("jobs", d.Jobs)
+		changed = printSectionDiff("pools", d.Pools) || changed
+		changed = printSectionDiff("notifiers", d.Notifiers) || changed
+	}
+	if !changed {
+		fmt.Println("no changes")
+	}
+	if len(result.ApiJobProblems) > 0 {
+		printProblems(result.ApiJobProblems)
+		fmt.Printf("%d API job problem(s). Those jobs are left out\n", len(result.ApiJobProblems))
+	}
+	if result.Applied {
+		fmt.Println("reloaded")
+	} else {
+		fmt.Println("dry run -- nothing applied")
+	}
 }
 
 func run(host, jobname string) {
@@ -85,16 +198,39 @@ func update_pool(host, pool string) {
 
 }
 
+// Convert an ini config to TOML, writing to stdout or the given file
+func convertConfig(args []string) {
+	out := os.Stdout
+	if len(args) > 1 {
+		f, err := os.Create(args[1])
+		if err != nil {
+			err_exit("Unable to create " + args[1] + ": " + err.Error())
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := config.ConvertToToml(args[0], out); err != nil {
+		err_exit("Unable to convert " + args[0] + ": " + err.Error())
+	}
+}
+
 func main() {
 	if len(os.Args) <= 1 {
-		err_exit("Syntax: scyctl <reload|test|run|fail> [job]")
+		err_exit("Syntax: scyctl <reload [--dry-run]|test|run|fail|convert-config> [job]")
+	}
+	cmd := os.Args[1]
+	if cmd == "convert-config" {
+		if len(os.Args) <= 2 {
+			err_exit("Syntax: scyctl convert-config <config.conf> [config.toml]")
+		}
+		convertConfig(os.Args[2:])
+		return
 	}
 	host := readHost()
 	fmt.Printf("Using host: %s\n", host)
-	cmd := os.Args[1]
 	switch cmd {
 	case "reload":
-		reload(host)
+		reload(host, len(os.Args) > 2 && os.Args[2] == "--dry-run")
 	case "run":
 		if len(os.Args) <= 2 {
 			err_exit("Syntax: sysctl run <jobname>")
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Job and pool names usable as file names
+var name_rex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Whether a name can be used for an API-defined job or a cached pool
+func ValidName(name string) bool {
+	return name_rex.MatchString(name)
+}
+
+// Jobs created through the API, one JSON JobSpec per file, as submitted
+func ApiJobDir() string {
+	return filepath.Join(RunDir(), "api-jobs")
+}
+
+func apiJobPath(name string) string {
+	return filepath.Join(ApiJobDir(), name+".json")
+}
+
+// Add the jobs created through the API to a config loaded by New. Each job is
+// resolved and checked like one submitted through the API. A job that can't
+// be read, clashes with a job in the config files or has problems is left out
+// and its problems returned, without failing the rest of the config
+func (cfg *Config) AddApiJobs() []Problem {
+	var problems problemList
+	files, _ := filepath.Glob(filepath.Join(ApiJobDir(), "*.json"))
+	for _, fn := range files {
+		name := strings.TrimSuffix(filepath.Base(fn), ".json")
+		job, err := readApiJob(fn)
+		if err != nil {
+			problems.add(section("job", name), "", "%s", err.Error())
+			continue
+		}
+		if prev := cfg.Job[name]; prev != nil {
+			problems.add(section("job", name), "", "defined in both %s and the API (%s)", prev.SourceFile, fn)
+			continue
+		}
+		job.Name = name
+		if err := cfg.ResolveApiJob(job); err != nil {
+			problems = append(problems, Problems(err)...)
+			continue
+		}
+		if cfg.Job == nil {
+			cfg.Job = make(map[string]*JobSpec)
+		}
+		cfg.Job[name] = job
+	}
+	sort.Sort(problems)
+	return problems
+}
+
+func readApiJob(fn string) (*JobSpec, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var job JobSpec
+	if err = json.Unmarshal(data, &job); err != nil {
+		return nil, errors.New("Unable to parse " + fn + ": " + err.Error())
+	}
+	return &job, nil
+}
+
+// Resolve and check a job submitted through the API against this config,
+// the same way as a job read from the config files. Returns a
+// *ValidationError listing every problem
+func (cfg *Config) ResolveApiJob(job *JobSpec) error {
+	var problems problemList
+	s := section("job", job.Name)
+	if !ValidName(job.Name) {
+		problems.add(s, "name", "must be letters, digits, '.', '_' or '-'")
+	}
+	table := make(map[string]string)
+	interpolateFields(reflect.ValueOf(job).Elem(), table, func(key string, err error) {
+		problems.add(s, key, "%s", err.Error())
+	})
+	job.SourceFile = apiJobPath(job.Name)
+	job.ApiDefined = true
+	cfg.applyTemplates(job, &problems)
+	cfg.resolveJob(job)
+	cfg.validateJob(job.Name, job, &problems)
+	if len(problems) == 0 {
+		if cfg.secrets == nil {
+			cfg.secrets = make(map[string]string)
+		}
+		for resolved, raw := range table {
+			cfg.secrets[resolved] = raw
+		}
+		addSecrets(table)
+		return nil
+	}
+	sort.Sort(problems)
+	return &ValidationError{Problems: problems}
+}
+
+// Store a job submitted through the API, as submitted
+func SaveApiJob(name string, data []byte) error {
+	os.MkdirAll(ApiJobDir(), 0755)
+	return ioutil.WriteFile(apiJobPath(name), data, 0644)
+}
+
+func DeleteApiJob(name string) error {
+	return os.Remove(apiJobPath(name))
+}
@@ -1,25 +1,73 @@
 package config
 
 import (
+	"bytes"
 	"errors"
-	"fmt"
-	"gopkg.in/gcfg.v1"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"scyd/cronsched"
 	"scyd/sched"
+	"scyd/ssh"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const DEFAULT_RUN_DIR = "/var/lib/scylla"
 const DEFAULT_CONNECT_TIMEOUT = 20
 const DEFAULT_READ_TIMEOUT = 86400
 const DEFAULT_MAX_RUN_HISTORY = 50
+const DEFAULT_HOST_KEY_POLICY = "insecure"
+const DEFAULT_POOL_MAX_SESSIONS = 1
+const DEFAULT_POOL_IDLE_TIMEOUT = 300
+const DEFAULT_KEEPALIVE_INTERVAL = 30
+const DEFAULT_CONNECT_RETRIES = 3
+const DEFAULT_CONNECT_RETRY_DELAY = 2
+const MAX_CONNECT_RETRY_DELAY = 60
+const DEFAULT_POOL_REFRESH_INTERVAL = 300
 
 type PoolSpec struct {
-	Name    string
-	Host    []string
-	Dynamic bool
+	Name          string
+	Host          []string
+	Dynamic       bool
+	MaxConcurrent int    `gcfg:"max-concurrent"`
+	KnownHosts    string `gcfg:"known-hosts"`
+	HostKeyPolicy string `gcfg:"host-key-policy"`
+	JumpHost      string `gcfg:"jump-host"`
+	HostCA        string `gcfg:"host-ca"`
+	// Connection retry policy. A pointer, so that 0 retries can be told from unset
+	ConnectRetries    *int `gcfg:"connect-retries"`
+	ConnectRetryDelay int  `gcfg:"connect-retry-delay"`
+	// Where to discover hosts (file:, exec: or dns-srv:), and how often in seconds
+	Source          []string
+	RefreshInterval int `gcfg:"refresh-interval"`
+	// Build the pool from others: hosts whose tags match the selector, plus the
+	// hosts of the union pools, less those of the except pools
+	Select string
+	Union  []string
+	Except []string
+	// Tags of each host that has any, set from the host entries
+	Tags map[string]map[string]string `json:",omitempty"`
+}
+
+// Named lock shared across jobs. Slots defaults to 1 (a mutex)
+type LockSpec struct {
+	Name  string
+	Slots int
+}
+
+// Per-host settings
+type HostSpec struct {
+	Name          string
+	MaxConcurrent int      `gcfg:"max-concurrent"`
+	Keyfile       []string // Keys used when this host is a jump host
+}
+
+// One hop of a resolved jump-host chain
+type JumpSpec struct {
+	Host    string
+	Keyfile []string `json:",omitempty"`
 }
 
 type JobSpec struct {
@@ -28,7 +76,8 @@ type JobSpec struct {
 	Description    string
 	Schedule       string
 	ScheduleInst   sched.Sched `json:"-"`
-	Keyfile        string
+	Keyfile        []string
+	Certfile       []string
 	Host           string
 	Pool           string
 	PoolMode       string
@@ -37,26 +86,96 @@ type JobSpec struct {
 	Upload         string
 	Sudo           bool
 	SudoCommand    string `gcfg:"sudo-command"`
+	SudoUser       string `gcfg:"sudo-user"`
 	ConnectTimeout int    `gcfg:"connect-timeout"`
 	ReadTimeout    int    `gcfg:"read-timeout"`
 	MaxRunHistory  int    `gcfg:"max-run-history"`
 	RunOnStart     bool   `gcfg:"run-on-start"`
 	FailsToNotify  int    `gcfg:"fails-to-notify"`
 	Notifier       string
+	// Quorum rules for parallel pool runs
+	SuccessThreshold string `gcfg:"success-threshold"`
+	MinSuccesses     int    `gcfg:"min-successes"`
+	// Named locks held for the duration of each run
+	Lock       []string
+	LockPolicy string `gcfg:"lock-policy"`
+	// Host key verification
+	KnownHosts    string `gcfg:"known-hosts"`
+	HostKeyPolicy string `gcfg:"host-key-policy"`
+	HostCA        string `gcfg:"host-ca"`
+	// ssh-agent. UseAgent is a pointer so that a job can turn off a default of yes
+	UseAgent        *bool `gcfg:"use-agent"`
+	AgentForwarding bool  `gcfg:"agent-forwarding"`
+	// Passphrase for encrypted key files
+	PassphraseFile string `gcfg:"passphrase-file"`
+	PassphraseEnv  string `gcfg:"passphrase-env"`
+	// Bastions, as a comma-separated chain. Resolved into JumpHosts
+	JumpHost  string `gcfg:"jump-host"`
+	JumpHosts []JumpSpec
+	// Defaults-level settings, kept apart so an ssh config can take precedence over them.
+	// Filled in by New, so neither read from the config nor saved
+	DefaultKeyfile   []string   `json:"-"`
+	DefaultCertfile  []string   `json:"-"`
+	DefaultJumpHosts []JumpSpec `json:"-"`
+	SshConfig        string     `gcfg:"ssh-config"`
+	// Kill a command after this many seconds without output (0 for never)
+	IdleOutputTimeout int `gcfg:"idle-output-timeout"`
+	// Seconds between keepalives while a command runs. Defaults to [connections]
+	KeepaliveInterval int `gcfg:"keepalive-interval"`
+	// Connection retry policy. The delay doubles after each failed attempt
+	ConnectRetries    *int `gcfg:"connect-retries"`
+	ConnectRetryDelay int  `gcfg:"connect-retry-delay"`
+	// Per-command options, matching Command. Only TOML configs set them
+	CommandOptions []CommandSpec `json:",omitempty"`
+	// Templates supplying unset attributes, in order. Later ones win
+	Template []string
+	// Config file the job was defined in, or its file under ApiJobDir
+	SourceFile string
+	ApiDefined bool // Created through the API rather than the config files
+}
+
+// Options for one of a job's commands, overriding the job's own
+type CommandSpec struct {
+	Sudo        *bool
+	ReadTimeout int `gcfg:"read-timeout"`
+}
+
+func (opts CommandSpec) String() string {
+	sudo := "job default"
+	if opts.Sudo != nil {
+		sudo = strconv.FormatBool(*opts.Sudo)
+	}
+	return "sudo: " + sudo + ", read-timeout: " + strconv.Itoa(opts.ReadTimeout)
 }
 
 type Defaults struct {
-	Keyfile        string
+	Keyfile        []string
+	Certfile       []string
 	ConnectTimeout int    `gcfg:"connect-timeout"`
 	ReadTimeout    int    `gcfg:"read-timeout"`
 	SudoCommand    string `gcfg:"sudo-command"`
 	User           string
 	Notifier       string
-	MaxRunHistory  int `gcfg:"max-run-history"`
+	MaxRunHistory  int    `gcfg:"max-run-history"`
+	KnownHosts     string `gcfg:"known-hosts"`
+	HostKeyPolicy  string `gcfg:"host-key-policy"`
+	UseAgent       bool   `gcfg:"use-agent"`
+	PassphraseFile string `gcfg:"passphrase-file"`
+	PassphraseEnv  string `gcfg:"passphrase-env"`
+	JumpHost       string `gcfg:"jump-host"`
+	HostCA         string `gcfg:"host-ca"`
+	SshConfig      string `gcfg:"ssh-config"`
+
+	IdleOutputTimeout int  `gcfg:"idle-output-timeout"`
+	ConnectRetries    *int `gcfg:"connect-retries"`
+	ConnectRetryDelay int  `gcfg:"connect-retry-delay"`
 }
 
 type General struct {
-	User string
+	User              string
+	MaxConcurrentRuns int      `gcfg:"max-concurrent-runs"`
+	WatchConfig       bool     `gcfg:"watch-config"` // Reload when the config file changes
+	Include           []string // Globs of further files holding pools, jobs etc
 }
 
 type Notifier struct {
@@ -65,28 +184,48 @@ type Notifier struct {
 	Args        []string `gcfg:"arg"`
 	EdgeTrigger bool     `gcfg:"edge-trigger"`
 	Always      bool
+	// Extra environment for the notifier. Only TOML configs set it
+	Env map[string]string `json:",omitempty"`
 }
 
 type Web struct {
 	Listen string
 }
 
+// Connection pool settings. Times are in seconds
+type Connections struct {
+	Pool              bool
+	MaxSessions       int `gcfg:"max-sessions"`
+	IdleTimeout       int `gcfg:"idle-timeout"`
+	KeepaliveInterval int `gcfg:"keepalive-interval"`
+}
+
 type Config struct {
-	General  General
-	Web      Web
-	Defaults Defaults
-	Pool     map[string]*PoolSpec
-	Job      map[string]*JobSpec
-	Notifier map[string]*Notifier
+	General     General
+	Web         Web
+	Connections Connections
+	Defaults    Defaults
+	Pool        map[string]*PoolSpec
+	Host        map[string]*HostSpec
+	Lock        map[string]*LockSpec
+	Job         map[string]*JobSpec
+	Template    map[string]*JobSpec // Attributes shared by jobs
+	Notifier    map[string]*Notifier
+
+	files         []string
+	load_problems problemList       // Found while reading, before validation
+	secrets       map[string]string // Resolved secret -> the string that referenced it
 }
 
+// Load a config file, filling in defaults. Any problems are returned together
+// as a *ValidationError
 func New(fn string) (cfg *Config, err error) {
 	var config = Config{}
 	cfg = &config
-	err = gcfg.ReadFileInto(cfg, fn)
-	if err == nil {
-		err = config.Validate()
+	if err = cfg.readFiles(fn); err != nil {
+		return nil, err
 	}
+	cfg.interpolate()
 	if cfg.Defaults.ConnectTimeout == 0 {
 		cfg.Defaults.ConnectTimeout = DEFAULT_CONNECT_TIMEOUT
 	}
@@ -96,68 +235,177 @@ func New(fn string) (cfg *Config, err error) {
 	if cfg.Defaults.MaxRunHistory == 0 {
 		cfg.Defaults.MaxRunHistory = DEFAULT_MAX_RUN_HISTORY
 	}
+	if cfg.Connections.MaxSessions == 0 {
+		cfg.Connections.MaxSessions = DEFAULT_POOL_MAX_SESSIONS
+	}
+	if cfg.Connections.IdleTimeout == 0 {
+		cfg.Connections.IdleTimeout = DEFAULT_POOL_IDLE_TIMEOUT
+	}
+	if cfg.Connections.KeepaliveInterval == 0 {
+		cfg.Connections.KeepaliveInterval = DEFAULT_KEEPALIVE_INTERVAL
+	}
 
-	// Cherry up pool hosts
+	// Cherry up pool hosts and split off their tags. Unparseable hosts are left
+	// for Validate to report
 	for name, pool := range cfg.Pool {
 		pool.Name = name
-	}
-
-	for name, notifier := range cfg.Notifier {
-		notifier.Name = name
-		if notifier.Path == "" {
-			return nil, errors.New(fmt.Sprintf("Notifier %s has no path set", name))
+		if pool.Derived() && (len(pool.Host) > 0 || len(pool.Source) > 0 || pool.Dynamic) {
+			cfg.load_problems.add(section("pool", name), "", "select, union and except cannot be combined with host, source or dynamic")
 		}
-		stat, err := os.Stat(notifier.Path)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Notifier %s -- cannot stat %s (%s)", name, notifier.Path, err.Error()))
+		if len(pool.Source) > 0 {
+			pool.Dynamic = true // Discovered hosts replace the configured ones
+			if pool.RefreshInterval == 0 {
+				pool.RefreshInterval = DEFAULT_POOL_REFRESH_INTERVAL
+			}
 		}
-		if (stat.Mode() & 0111) == 0 {
-			return nil, errors.New(fmt.Sprintf("Notifier %s -- %s must be executable", name, notifier.Path))
+		for i, entry := range pool.Host {
+			if host, tags, err := ParsePoolHost(entry); err == nil {
+				pool.Host[i] = host
+				if len(tags) > 0 {
+					if pool.Tags == nil {
+						pool.Tags = make(map[string]map[string]string)
+					}
+					pool.Tags[host] = tags
+				}
+			}
 		}
 	}
-	if cfg.Defaults.Notifier != "" && cfg.Notifier[cfg.Defaults.Notifier] == nil {
-		return nil, errors.New(fmt.Sprintf("Default Notifier %s does not exist)", cfg.Defaults.Notifier))
+	cfg.resolvePools(&cfg.load_problems)
+	for name, host := range cfg.Host {
+		host.Name = name
+	}
+	for name, lock := range cfg.Lock {
+		lock.Name = name
+		if lock.Slots == 0 {
+			lock.Slots = 1
+		}
+	}
+	for name, notifier := range cfg.Notifier {
+		notifier.Name = name
+	}
+	for name, tmpl := range cfg.Template {
+		tmpl.Name = name
 	}
-
-	// Parse the schedule data, set defaults
 	for name, job := range cfg.Job {
 		job.Name = name
-		job.ParseSchedule()
-		if err != nil {
-			return nil, err
+		cfg.applyTemplates(job, &cfg.load_problems)
+	}
+
+	// Parse the schedule data, set defaults
+	for _, job := range cfg.Job {
+		cfg.resolveJob(job)
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.installSecrets()
+	return cfg, nil
+}
+
+// Parse a job's schedule and fill in everything it leaves unset from the
+// pool, defaults and connection settings
+func (cfg *Config) resolveJob(job *JobSpec) {
+	job.ParseSchedule()
+	if job.ConnectTimeout == 0 {
+		job.ConnectTimeout = cfg.Defaults.ConnectTimeout
+	}
+	if job.ReadTimeout == 0 {
+		job.ReadTimeout = cfg.Defaults.ReadTimeout
+	}
+	if job.MaxRunHistory == 0 {
+		job.MaxRunHistory = cfg.Defaults.MaxRunHistory
+	}
+	if job.SudoCommand == "" {
+		job.SudoCommand = cfg.Defaults.SudoCommand
+	}
+	if job.SudoUser != "" {
+		job.Sudo = true // Running as another user implies sudo
+	}
+	if job.IdleOutputTimeout == 0 {
+		job.IdleOutputTimeout = cfg.Defaults.IdleOutputTimeout
+	}
+	if job.KeepaliveInterval == 0 {
+		job.KeepaliveInterval = cfg.Connections.KeepaliveInterval
+	}
+	job.DefaultKeyfile = cfg.Defaults.Keyfile
+	job.DefaultCertfile = cfg.Defaults.Certfile
+	if job.SshConfig == "" {
+		job.SshConfig = cfg.Defaults.SshConfig
+	}
+	if job.PassphraseFile == "" && job.PassphraseEnv == "" {
+		job.PassphraseFile = cfg.Defaults.PassphraseFile
+		job.PassphraseEnv = cfg.Defaults.PassphraseEnv
+	}
+	if job.UseAgent == nil {
+		use_agent := cfg.Defaults.UseAgent
+		job.UseAgent = &use_agent
+	}
+	if job.Notifier == "" {
+		job.Notifier = cfg.Defaults.Notifier
+	}
+	job.DefaultUser = cfg.Defaults.User
+	job.Lock = uniqueNames(job.Lock) // A lock listed twice still takes one slot
+	if job.Host != "" {
+		if spec, err := ssh.ParseServerSpec(job.Host); err == nil {
+			job.Host = spec.String()
 		}
-		if job.ConnectTimeout == 0 {
-			job.ConnectTimeout = cfg.Defaults.ConnectTimeout
+	}
+	if job.Pool != "" {
+		p := strings.Fields(job.Pool)
+		if len(p) > 1 {
+			job.PoolMode = p[1]
 		}
-		if job.ReadTimeout == 0 {
-			job.ReadTimeout = cfg.Defaults.ReadTimeout
+		if len(p) > 0 {
+			job.PoolInst = cfg.Pool[p[0]]
 		}
-		if job.MaxRunHistory == 0 {
-			job.MaxRunHistory = cfg.Defaults.MaxRunHistory
+	}
+	if job.PoolInst != nil {
+		if job.KnownHosts == "" {
+			job.KnownHosts = job.PoolInst.KnownHosts
 		}
-		if job.Keyfile == "" {
-			job.Keyfile = cfg.Defaults.Keyfile
+		if job.HostKeyPolicy == "" {
+			job.HostKeyPolicy = job.PoolInst.HostKeyPolicy
 		}
-		if job.Notifier == "" {
-			job.Notifier = cfg.Defaults.Notifier
+		if job.HostCA == "" {
+			job.HostCA = job.PoolInst.HostCA
 		}
-		job.DefaultUser = cfg.Defaults.User
-		if job.Pool != "" {
-			p := strings.Split(job.Pool, " ")
-			if len(p) > 1 {
-				job.PoolMode = p[1]
-			}
-			job.PoolInst = cfg.Pool[p[0]]
-			if job.PoolInst == nil {
-				return nil, errors.New(fmt.Sprintf("Bad pool %s specified by job %s", name, p[0]))
-			}
+		if job.ConnectRetries == nil {
+			job.ConnectRetries = job.PoolInst.ConnectRetries
 		}
-		if job.Notifier != "" && cfg.Notifier[job.Notifier] == nil {
-			return nil, errors.New(fmt.Sprintf("Bad notifier %s specified by job %s", job.Notifier, name))
+		if job.ConnectRetryDelay == 0 {
+			job.ConnectRetryDelay = job.PoolInst.ConnectRetryDelay
 		}
 	}
-
-	return cfg, err
+	if job.ConnectRetries == nil {
+		job.ConnectRetries = cfg.Defaults.ConnectRetries
+	}
+	if job.ConnectRetryDelay == 0 {
+		job.ConnectRetryDelay = cfg.Defaults.ConnectRetryDelay
+	}
+	if job.ConnectRetryDelay == 0 {
+		job.ConnectRetryDelay = DEFAULT_CONNECT_RETRY_DELAY
+	}
+	if job.HostCA == "" {
+		job.HostCA = cfg.Defaults.HostCA
+	}
+	if job.KnownHosts == "" {
+		job.KnownHosts = cfg.Defaults.KnownHosts
+	}
+	if job.JumpHost == "" && job.PoolInst != nil {
+		job.JumpHost = job.PoolInst.JumpHost
+	}
+	job.JumpHosts = cfg.jumpHosts(job.JumpHost)
+	job.DefaultJumpHosts = cfg.jumpHosts(cfg.Defaults.JumpHost)
+	if job.HostKeyPolicy == "" {
+		job.HostKeyPolicy = cfg.Defaults.HostKeyPolicy
+	}
+	if job.HostKeyPolicy == "" {
+		job.HostKeyPolicy = DEFAULT_HOST_KEY_POLICY
+	}
+	if job.LockPolicy == "" {
+		job.LockPolicy = "wait"
+	}
 }
 
 func (job *JobSpec) ParseSchedule() error {
@@ -178,8 +426,159 @@ func (job *JobSpec) ParseSchedule() error {
 	return err
 }
 
-func (cfg *Config) Validate() (err error) {
-	return err
+// Key and certificate files for a host. Explicit job keys win over the
+// host's IdentityFiles from the ssh config, which win over the defaults.
+// Default certificates are for the default keys, so are never paired with
+// IdentityFiles
+func (job *JobSpec) Keys(identity_files []string) (keyfiles []string, certfiles []string) {
+	certfiles = job.Certfile
+	if len(certfiles) == 0 && len(identity_files) == 0 {
+		certfiles = job.DefaultCertfile
+	}
+	if len(job.Keyfile) > 0 {
+		return job.Keyfile, certfiles
+	}
+	if len(identity_files) > 0 {
+		return identity_files, certfiles
+	}
+	return job.DefaultKeyfile, certfiles
+}
+
+// Resolve a comma-separated jump host chain, picking up per-host keys
+func (cfg *Config) jumpHosts(chain string) []JumpSpec {
+	hops := []JumpSpec{}
+	for _, hop := range strings.Split(chain, ",") {
+		hop = strings.TrimSpace(hop)
+		if hop == "" {
+			continue
+		}
+		spec := JumpSpec{Host: hop}
+		if host := cfg.Host[HostName(hop)]; host != nil {
+			spec.Keyfile = host.Keyfile
+		}
+		hops = append(hops, spec)
+	}
+	return hops
+}
+
+// Bare host name from a [user@]host[:port] string. Unparseable strings are returned as is
+func HostName(server string) string {
+	if spec, err := ssh.ParseServerSpec(server); err == nil {
+		return spec.Host
+	}
+	return server
+}
+
+// Passphrase for the job's encrypted key files, from passphrase-file or
+// passphrase-env. Returns nil if neither is set
+func (job *JobSpec) KeyPassphrase() ([]byte, error) {
+	if job.PassphraseFile != "" {
+		data, err := ioutil.ReadFile(job.PassphraseFile)
+		if err != nil {
+			return nil, errors.New("Unable to read passphrase file: " + err.Error())
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+	if job.PassphraseEnv != "" {
+		passphrase := os.Getenv(job.PassphraseEnv)
+		if passphrase == "" {
+			return nil, errors.New("Passphrase environment variable not set: " + job.PassphraseEnv)
+		}
+		return []byte(passphrase), nil
+	}
+	return nil, nil
+}
+
+// Parse the success-threshold attribute ("80%" or "80"). Returns 0 if unset
+func (job *JobSpec) SuccessPercent() (int, error) {
+	threshold := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(job.SuccessThreshold), "%"))
+	if threshold == "" {
+		return 0, nil
+	}
+	pct, err := strconv.Atoi(threshold)
+	if err != nil {
+		return 0, errors.New("Unable to parse percentage: " + job.SuccessThreshold)
+	}
+	if pct <= 0 || pct > 100 {
+		return 0, errors.New("Percentage out of range: " + job.SuccessThreshold)
+	}
+	return pct, nil
+}
+
+// Number of host runs that must succeed for a run across the given
+// number of hosts to succeed. Without quorum rules, every host must succeed.
+// Never more than the number of hosts, so a pool that shrinks below
+// min-successes can still succeed
+func (job *JobSpec) RequiredSuccesses(hosts int) int {
+	pct, _ := job.SuccessPercent()
+	if pct == 0 && job.MinSuccesses == 0 {
+		return hosts
+	}
+	required := (hosts*pct + 99) / 100
+	if job.MinSuccesses > required {
+		required = job.MinSuccesses
+	}
+	if required > hosts {
+		required = hosts
+	}
+	return required
+}
+
+// Whether to sudo, and the read timeout, for the job's i'th command
+func (job *JobSpec) CommandSettings(i int) (sudo bool, read_timeout int) {
+	sudo, read_timeout = job.Sudo, job.ReadTimeout
+	if i < len(job.CommandOptions) {
+		opts := job.CommandOptions[i]
+		if opts.Sudo != nil {
+			sudo = *opts.Sudo
+		}
+		if opts.ReadTimeout != 0 {
+			read_timeout = opts.ReadTimeout
+		}
+	}
+	return sudo, read_timeout
+}
+
+// Whether to authenticate with the ssh-agent
+func (job *JobSpec) UsesAgent() bool {
+	return job.UseAgent != nil && *job.UseAgent
+}
+
+// Number of times to retry a failed connection
+func (job *JobSpec) Retries() int {
+	if job.ConnectRetries == nil {
+		return DEFAULT_CONNECT_RETRIES
+	}
+	return *job.ConnectRetries
+}
+
+// Delay before the given retry (counting from 0), doubling each time up to
+// MAX_CONNECT_RETRY_DELAY
+func (job *JobSpec) RetryDelay(retry int) time.Duration {
+	delay := job.ConnectRetryDelay
+	if delay == 0 {
+		delay = DEFAULT_CONNECT_RETRY_DELAY
+	}
+	for i := 0; i < retry && delay < MAX_CONNECT_RETRY_DELAY; i++ {
+		delay *= 2
+	}
+	if delay > MAX_CONNECT_RETRY_DELAY {
+		delay = MAX_CONNECT_RETRY_DELAY
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// Split a pool source into its kind (file, exec or dns-srv) and argument
+func ParsePoolSource(source string) (kind string, arg string, err error) {
+	parts := strings.SplitN(strings.TrimSpace(source), ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		return "", "", errors.New("Expected file:<path>, exec:<command> or dns-srv:<name>, got " + source)
+	}
+	switch parts[0] {
+	case "file", "exec", "dns-srv":
+		return parts[0], strings.TrimSpace(parts[1]), nil
+	}
+	return "", "", errors.New("Unknown pool source type: " + parts[0])
 }
 
 func RunDir() string {
@@ -194,6 +593,11 @@ func JobDir() string {
 	return filepath.Join(RunDir(), "jobs")
 }
 
+// Scylla-managed known_hosts file, used for trust-on-first-use host keys
+func KnownHostsPath() string {
+	return filepath.Join(RunDir(), "known_hosts")
+}
+
 func PoolCacheDir() string {
 	return filepath.Join(RunDir(), "pools")
 }
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// A changed attribute. Values are formatted for display
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+type SectionChange struct {
+	Name   string
+	Fields []FieldChange
+}
+
+type ChangesByName []SectionChange
+
+func (slice ChangesByName) Len() int           { return len(slice) }
+func (slice ChangesByName) Less(i, j int) bool { return slice[i].Name < slice[j].Name }
+func (slice ChangesByName) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+
+// Sections of one kind (jobs, pools or notifiers) that differ between configs
+type SectionDiff struct {
+	Added   []string
+	Removed []string
+	Changed []SectionChange
+}
+
+// What a reload would change
+type ConfigDiff struct {
+	Jobs      SectionDiff
+	Pools     SectionDiff
+	Notifiers SectionDiff
+}
+
+func (d *SectionDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d *ConfigDiff) Empty() bool {
+	return d.Jobs.Empty() && d.Pools.Empty() && d.Notifiers.Empty()
+}
+
+// Compare two configs. old may be nil, in which case everything is added
+func Diff(old *Config, new *Config) ConfigDiff {
+	if old == nil {
+		old = &Config{}
+	}
+	return ConfigDiff{
+		Jobs:      diffSections(reflect.ValueOf(old.Job), reflect.ValueOf(new.Job), old.secrets, new.secrets),
+		Pools:     diffSections(reflect.ValueOf(old.Pool), reflect.ValueOf(new.Pool), old.secrets, new.secrets),
+		Notifiers: diffSections(reflect.ValueOf(old.Notifier), reflect.ValueOf(new.Notifier), old.secrets, new.secrets),
+	}
+}
+
+// Compare two maps of section name -> *spec. Secrets are redacted with the
+// table of the config each side came from
+func diffSections(old reflect.Value, new reflect.Value, old_secrets map[string]string, new_secrets map[string]string) SectionDiff {
+	diff := SectionDiff{Added: []string{}, Removed: []string{}, Changed: []SectionChange{}}
+	for _, key := range new.MapKeys() {
+		old_spec := old.MapIndex(key)
+		if !old_spec.IsValid() || old_spec.IsNil() {
+			diff.Added = append(diff.Added, key.String())
+			continue
+		}
+		if fields := diffFields(old_spec.Elem(), new.MapIndex(key).Elem(), old_secrets, new_secrets); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, SectionChange{Name: key.String(), Fields: fields})
+		}
+	}
+	for _, key := range old.MapKeys() {
+		if new_spec := new.MapIndex(key); !new_spec.IsValid() || new_spec.IsNil() {
+			diff.Removed = append(diff.Removed, key.String())
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Sort(ChangesByName(diff.Changed))
+	return diff
+}
+
+// Compare the exported, serialized fields of two structs of the same type.
+// Fields are named by their config key where they have one
+func diffFields(old reflect.Value, new reflect.Value, old_secrets map[string]string, new_secrets map[string]string) []FieldChange {
+	changes := []FieldChange{}
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" || f.Name == "Name" {
+			continue
+		}
+		if reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: configKey(f), Old: display(old.Field(i), old_secrets), New: display(new.Field(i), new_secrets)})
+	}
+	return changes
+}
+
+// Format a field's value, swapping each string, list element or map value
+// that held a secret back for its reference
+func display(v reflect.Value, table map[string]string) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	redact := func(value string) string {
+		if raw, found := table[value]; found {
+			return raw
+		}
+		return value
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		return redact(v.String())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = redact(v.Index(i).String())
+		}
+		return fmt.Sprintf("%v", values)
+	case v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.String:
+		values := make(map[string]string)
+		for _, k := range v.MapKeys() {
+			values[k.String()] = redact(v.MapIndex(k).String())
+		}
+		return fmt.Sprintf("%v", values)
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// Where each named section was defined, keyed by section (eg `job "backup"`)
+type sectionSources map[string]string
+
+// Record that fn defines a section, reporting a problem if another file already did
+func (s sectionSources) claim(kind string, name string, fn string, problems *problemList) bool {
+	key := section(kind, name)
+	if prev, ok := s[key]; ok {
+		problems.add(key, "", "defined in both %s and %s", prev, fn)
+		return false
+	}
+	s[key] = fn
+	return true
+}
+
+// Read a config file and merge in the files named by its include globs.
+// Included files may only hold pool, host, lock, job, template and notifier sections
+func (cfg *Config) readFiles(fn string) error {
+	if err := readFile(cfg, fn); err != nil {
+		return err
+	}
+	cfg.files = []string{fn}
+	sources := sectionSources{}
+	for name, job := range cfg.Job {
+		job.SourceFile = fn
+		sources.claim("job", name, fn, nil)
+	}
+	for name := range cfg.Pool {
+		sources.claim("pool", name, fn, nil)
+	}
+	for name := range cfg.Host {
+		sources.claim("host", name, fn, nil)
+	}
+	for name := range cfg.Lock {
+		sources.claim("lock", name, fn, nil)
+	}
+	for name := range cfg.Notifier {
+		sources.claim("notifier", name, fn, nil)
+	}
+	for name := range cfg.Template {
+		sources.claim("template", name, fn, nil)
+	}
+
+	includes, dirs := cfg.expandIncludes(fn)
+	cfg.files = append(cfg.files, dirs...) // So new files are noticed
+	for _, inc := range includes {
+		var part Config
+		if err := readFile(&part, inc); err != nil {
+			return err
+		}
+		cfg.files = append(cfg.files, inc)
+		if !reflect.DeepEqual(part.General, General{}) || !reflect.DeepEqual(part.Web, Web{}) ||
+			!reflect.DeepEqual(part.Connections, Connections{}) || !reflect.DeepEqual(part.Defaults, Defaults{}) {
+			cfg.load_problems.add("general", "include", "%s: only pool, host, lock, job, template and notifier sections may be included", inc)
+		}
+		for name, job := range part.Job {
+			if sources.claim("job", name, inc, &cfg.load_problems) {
+				if cfg.Job == nil {
+					cfg.Job = make(map[string]*JobSpec)
+				}
+				job.SourceFile = inc
+				cfg.Job[name] = job
+			}
+		}
+		for name, pool := range part.Pool {
+			if sources.claim("pool", name, inc, &cfg.load_problems) {
+				if cfg.Pool == nil {
+					cfg.Pool = make(map[string]*PoolSpec)
+				}
+				cfg.Pool[name] = pool
+			}
+		}
+		for name, host := range part.Host {
+			if sources.claim("host", name, inc, &cfg.load_problems) {
+				if cfg.Host == nil {
+					cfg.Host = make(map[string]*HostSpec)
+				}
+				cfg.Host[name] = host
+			}
+		}
+		for name, lock := range part.Lock {
+			if sources.claim("lock", name, inc, &cfg.load_problems) {
+				if cfg.Lock == nil {
+					cfg.Lock = make(map[string]*LockSpec)
+				}
+				cfg.Lock[name] = lock
+			}
+		}
+		for name, notifier := range part.Notifier {
+			if sources.claim("notifier", name, inc, &cfg.load_problems) {
+				if cfg.Notifier == nil {
+					cfg.Notifier = make(map[string]*Notifier)
+				}
+				cfg.Notifier[name] = notifier
+			}
+		}
+		for name, tmpl := range part.Template {
+			if sources.claim("template", name, inc, &cfg.load_problems) {
+				if cfg.Template == nil {
+					cfg.Template = make(map[string]*JobSpec)
+				}
+				cfg.Template[name] = tmpl
+			}
+		}
+	}
+	return nil
+}
+
+// Files matching the include globs, in order, plus the directories they were
+// found in. Relative globs are taken from the including file's directory
+func (cfg *Config) expandIncludes(fn string) (files []string, dirs []string) {
+	seen := make(map[string]bool)
+	for _, pattern := range cfg.General.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(fn), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			cfg.load_problems.add("general", "include", "bad pattern %q (%s)", pattern, err.Error())
+			continue
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+		if dir := filepath.Dir(pattern); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return files, dirs
+}
+
+// Files the config was read from, including directories searched for
+// includes. Empty for configs not loaded by New
+func (cfg *Config) Files() []string {
+	return cfg.files
+}
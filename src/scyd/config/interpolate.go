@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ${ENV:NAME} or ${FILE:/path}
+var reference_rex = regexp.MustCompile(`\$\{(ENV|FILE):([^}]*)\}`)
+
+// Config strings that held secrets, keyed by their resolved values, so that
+// secrets can be swapped back for the references wherever they're shown.
+// Replaced by each config that loads successfully
+var secrets = struct {
+	sync.Mutex
+	raw map[string]string
+}{raw: make(map[string]string)}
+
+// Shortest referenced value treated as a secret. Shorter ones (a port, say)
+// are too likely to turn up as ordinary values
+const MIN_SECRET_LENGTH = 6
+
+// Resolve the references in a string. Also reports whether the result holds
+// secrets worth redacting, which is when every reference supplied at least
+// MIN_SECRET_LENGTH characters
+func interpolate(value string) (string, bool, error) {
+	var err error
+	secret := true
+	resolved := reference_rex.ReplaceAllStringFunc(value, func(ref string) string {
+		m := reference_rex.FindStringSubmatch(ref)
+		var val string
+		if m[1] == "ENV" {
+			var ok bool
+			if val, ok = os.LookupEnv(m[2]); !ok && err == nil {
+				err = errors.New("environment variable not set: " + m[2])
+			}
+		} else {
+			data, ferr := ioutil.ReadFile(m[2])
+			if ferr != nil && err == nil {
+				err = errors.New("unable to read " + m[2] + ": " + ferr.Error())
+			}
+			val = string(bytes.TrimRight(data, "\r\n"))
+		}
+		secret = secret && len(val) >= MIN_SECRET_LENGTH
+		return val
+	})
+	if err != nil {
+		return value, false, err
+	}
+	return resolved, secret && resolved != value, nil
+}
+
+// Resolve references in every string and string list field of a struct,
+// adding the secrets found to table. Problems are reported with the field's
+// config key
+func interpolateFields(v reflect.Value, table map[string]string, report func(key string, err error)) {
+	resolve := func(key string, value string) string {
+		resolved, secret, err := interpolate(value)
+		if err != nil {
+			report(key, err)
+		}
+		if secret {
+			table[resolved] = value
+		}
+		return resolved
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		key := configKey(f)
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.String:
+			field.SetString(resolve(key, field.String()))
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+			for j := 0; j < field.Len(); j++ {
+				field.Index(j).SetString(resolve(key, field.Index(j).String()))
+			}
+		case field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.String:
+			for _, k := range field.MapKeys() {
+				field.SetMapIndex(k, reflect.ValueOf(resolve(key, field.MapIndex(k).String())))
+			}
+		}
+	}
+}
+
+// Resolve references throughout a freshly read config, section by section.
+// The secrets found are kept for New to install once the config is valid
+func (cfg *Config) interpolate() {
+	cfg.secrets = make(map[string]string)
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		kind := strings.ToLower(f.Name)
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			interpolateFields(field, cfg.secrets, func(key string, err error) {
+				cfg.load_problems.add(kind, key, "%s", err.Error())
+			})
+		case reflect.Map:
+			for _, name := range field.MapKeys() {
+				s := section(kind, name.String())
+				interpolateFields(field.MapIndex(name).Elem(), cfg.secrets, func(key string, err error) {
+					cfg.load_problems.add(s, key, "%s", err.Error())
+				})
+			}
+		}
+	}
+}
+
+// Resolve references in a job spec restored from a redacted state file
+func (job *JobSpec) Interpolate() error {
+	var first error
+	table := make(map[string]string)
+	interpolateFields(reflect.ValueOf(job).Elem(), table, func(key string, err error) {
+		if first == nil {
+			first = errors.New(key + ": " + err.Error())
+		}
+	})
+	addSecrets(table)
+	return first
+}
+
+// Add secrets to those of the running config
+func addSecrets(table map[string]string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for resolved, raw := range table {
+		secrets.raw[resolved] = raw
+	}
+}
+
+// Make a newly loaded config's secrets the ones to redact, dropping any that
+// have since been rotated away
+func (cfg *Config) installSecrets() {
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.raw = make(map[string]string)
+	for resolved, raw := range cfg.secrets {
+		secrets.raw[resolved] = raw
+	}
+}
+
+// The reference a value came from, if the whole value held a secret. Values
+// are never redacted piecemeal, so ordinary strings that happen to contain a
+// secret's text (a timestamp, say) are left alone
+func Redact(value string) string {
+	secrets.Lock()
+	defer secrets.Unlock()
+	if raw, found := secrets.raw[value]; found {
+		return raw
+	}
+	return value
+}
+
+// Marshal v to JSON with every string value that holds a secret redacted
+func RedactedJson(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // Leave large integers alone
+	var generic interface{}
+	if err = decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactValue(generic))
+}
+
+func redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return Redact(val)
+	case []interface{}:
+		for i := range val {
+			val[i] = redactValue(val[i])
+		}
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = redactValue(val[k])
+		}
+	}
+	return v
+}
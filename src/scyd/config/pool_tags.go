@@ -0,0 +1,285 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"scyd/ssh"
+	"sort"
+	"strings"
+)
+
+// Tag keys and values, eg role=web or region=us-east-1
+var tag_rex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// One term of a selector. An empty Value only checks whether the tag is set
+type selectorTerm struct {
+	Key    string
+	Value  string
+	Negate bool
+}
+
+// Picks hosts by their tags. Every term must match
+type Selector []selectorTerm
+
+// Parse a comma-separated selector. Terms are key=value, key!=value, key (the
+// tag is set) and !key (the tag is not set)
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		term := selectorTerm{}
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			term = selectorTerm{Key: kv[0], Value: kv[1], Negate: true}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			term = selectorTerm{Key: kv[0], Value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			term = selectorTerm{Key: part[1:], Negate: true}
+		default:
+			term = selectorTerm{Key: part}
+		}
+		term.Key, term.Value = strings.TrimSpace(term.Key), strings.TrimSpace(term.Value)
+		if !tag_rex.MatchString(term.Key) || (term.Value != "" && !tag_rex.MatchString(term.Value)) {
+			return nil, errors.New(fmt.Sprintf("Bad selector term %q", part))
+		}
+		if strings.Contains(part, "=") && term.Value == "" {
+			return nil, errors.New(fmt.Sprintf("Bad selector term %q: no value", part))
+		}
+		sel = append(sel, term)
+	}
+	return sel, nil
+}
+
+func (sel Selector) Matches(tags map[string]string) bool {
+	for _, term := range sel {
+		value, found := tags[term.Key]
+		match := found
+		if term.Value != "" {
+			match = found && value == term.Value
+		}
+		if match == term.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Split a pool host entry into the canonical host and its tags, eg
+// `web-1 role=web,region=east`
+func ParsePoolHost(entry string) (string, map[string]string, error) {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 {
+		return "", nil, errors.New("Empty host")
+	}
+	spec, err := ssh.ParseServerSpec(fields[0])
+	if err != nil {
+		return "", nil, err
+	}
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(strings.Join(fields[1:], ","), ",") {
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || !tag_rex.MatchString(kv[0]) || !tag_rex.MatchString(kv[1]) {
+			return "", nil, errors.New(fmt.Sprintf("Bad tag %q for host %s: expected key=value", tag, fields[0]))
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return spec.String(), tags, nil
+}
+
+// Pool host entry for a host and its tags, the reverse of ParsePoolHost
+func PoolHostEntry(host string, tags map[string]string) string {
+	pairs := []string{}
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	if len(pairs) == 0 {
+		return host
+	}
+	sort.Strings(pairs)
+	return host + " " + strings.Join(pairs, ",")
+}
+
+// Hosts and tags from pool host entries. Entries that cannot be parsed are
+// skipped
+func ParsePoolHosts(entries []string) ([]string, map[string]map[string]string) {
+	members := poolMembers{}
+	for _, entry := range entries {
+		if host, tags, err := ParsePoolHost(entry); err == nil {
+			members.add(host, tags)
+		}
+	}
+	return members.result()
+}
+
+// Hosts in order without duplicates, along with their tags
+type poolMembers struct {
+	hosts []string
+	tags  map[string]map[string]string
+}
+
+func (m *poolMembers) add(host string, tags map[string]string) {
+	if m.tags == nil {
+		m.tags = make(map[string]map[string]string)
+	}
+	if _, found := m.tags[host]; !found {
+		m.hosts = append(m.hosts, host)
+		m.tags[host] = make(map[string]string)
+	}
+	for key, value := range tags {
+		m.tags[host][key] = value
+	}
+}
+
+func (m *poolMembers) remove(host string) {
+	if _, found := m.tags[host]; !found {
+		return
+	}
+	delete(m.tags, host)
+	for i, h := range m.hosts {
+		if h == host {
+			m.hosts = append(m.hosts[:i], m.hosts[i+1:]...)
+			break
+		}
+	}
+}
+
+// The hosts, and the tags of those that have any. Tags are nil if no host has any
+func (m *poolMembers) result() ([]string, map[string]map[string]string) {
+	hosts := append([]string{}, m.hosts...)
+	var tags map[string]map[string]string
+	for host, host_tags := range m.tags {
+		if len(host_tags) == 0 {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]map[string]string)
+		}
+		tags[host] = host_tags
+	}
+	return hosts, tags
+}
+
+// True for pools built from other pools with select, union or except
+func (pool *PoolSpec) Derived() bool {
+	return pool.Select != "" || len(pool.Union) > 0 || len(pool.Except) > 0
+}
+
+// True for dynamic pools and pools built from them, whose hosts are not known
+// until the dynamic pools are filled in
+func (cfg *Config) dynamicHosts(name string, visiting map[string]bool) bool {
+	pool := cfg.Pool[name]
+	if pool == nil || visiting[name] {
+		return false
+	}
+	if pool.Dynamic {
+		return true
+	}
+	visiting[name] = true
+	if pool.Select != "" {
+		for _, other := range cfg.Pool {
+			if !other.Derived() && other.Dynamic {
+				return true
+			}
+		}
+	}
+	for _, other := range append(nameList(pool.Union), nameList(pool.Except)...) {
+		if cfg.dynamicHosts(other, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *Config) sortedPoolNames() []string {
+	names := []string{}
+	for name := range cfg.Pool {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Work out the hosts of every pool built from other pools
+func (cfg *Config) resolvePools(problems *problemList) {
+	resolved := make(map[string]bool)
+	for _, name := range cfg.sortedPoolNames() {
+		cfg.resolvePool(name, resolved, make(map[string]bool), problems)
+	}
+}
+
+// Hosts of a derived pool: those picked by its selector from the pools that
+// list hosts, plus the hosts of its union pools, less those of its except
+// pools. The pools it is built from are resolved first
+func (cfg *Config) resolvePool(name string, resolved map[string]bool, visiting map[string]bool, problems *problemList) {
+	pool := cfg.Pool[name]
+	if pool == nil || resolved[name] || !pool.Derived() {
+		return
+	}
+	if visiting[name] {
+		problems.add(section("pool", name), "", "pools are built from each other in a loop")
+		return
+	}
+	visiting[name] = true
+	for _, other := range append(nameList(pool.Union), nameList(pool.Except)...) {
+		cfg.resolvePool(other, resolved, visiting, problems)
+	}
+	members := poolMembers{}
+	if sel, err := ParseSelector(pool.Select); pool.Select != "" && err == nil {
+		for _, other_name := range cfg.sortedPoolNames() {
+			other := cfg.Pool[other_name]
+			if other.Derived() {
+				continue
+			}
+			for _, host := range other.Host {
+				if sel.Matches(other.Tags[host]) {
+					members.add(host, other.Tags[host])
+				}
+			}
+		}
+	}
+	for _, other_name := range nameList(pool.Union) {
+		if other := cfg.Pool[other_name]; other != nil {
+			for _, host := range other.Host {
+				members.add(host, other.Tags[host])
+			}
+		}
+	}
+	for _, other_name := range nameList(pool.Except) {
+		if other := cfg.Pool[other_name]; other != nil {
+			for _, host := range other.Host {
+				members.remove(host)
+			}
+		}
+	}
+	pool.Host, pool.Tags = members.result()
+	resolved[name] = true
+}
+
+// Replace a dynamic pool's hosts, which may carry tags, and re-resolve the
+// pools built from it. Returns the names of the pools whose hosts changed
+func (cfg *Config) SetPoolHosts(name string, entries []string) []string {
+	pool := cfg.Pool[name]
+	if pool == nil || !pool.Dynamic {
+		return []string{}
+	}
+	before := make(map[string]string)
+	for pool_name, p := range cfg.Pool {
+		before[pool_name] = fmt.Sprintf("%v %v", p.Host, p.Tags)
+	}
+	pool.Host, pool.Tags = ParsePoolHosts(entries)
+	cfg.resolvePools(&problemList{})
+	changed := []string{}
+	for _, pool_name := range cfg.sortedPoolNames() {
+		p := cfg.Pool[pool_name]
+		if fmt.Sprintf("%v %v", p.Host, p.Tags) != before[pool_name] {
+			changed = append(changed, pool_name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Names from a list attribute, such as a job's templates, where each value may
+// hold a comma-separated list
+func nameList(values []string) []string {
+	names := []string{}
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Names in order with repeats dropped
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool)
+	unique := []string{}
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// Templates to layer under a job, least specific first. Templates may use
+// other templates, which come before them
+func (cfg *Config) templateChain(names []string, path []string, problems *problemList, s string) []*JobSpec {
+	chain := []*JobSpec{}
+	for _, name := range nameList(names) {
+		tmpl := cfg.Template[name]
+		if tmpl == nil {
+			problems.add(s, "template", "template %q does not exist", name)
+			continue
+		}
+		cycle := false
+		for _, seen := range path {
+			cycle = cycle || seen == name
+		}
+		if cycle {
+			problems.add(s, "template", "template %q uses itself (%s -> %s)", name, strings.Join(path, " -> "), name)
+			continue
+		}
+		chain = append(chain, cfg.templateChain(tmpl.Template, append(path, name), problems, s)...)
+		chain = append(chain, tmpl)
+	}
+	return chain
+}
+
+// Fill in the attributes a job leaves unset from its templates. Later
+// templates override earlier ones, and the job overrides them all. Lists
+// such as command are replaced whole, never merged, and booleans can only
+// be switched on
+func (cfg *Config) applyTemplates(job *JobSpec, problems *problemList) {
+	chain := cfg.templateChain(job.Template, []string{}, problems, section("job", job.Name))
+	for i := len(chain) - 1; i >= 0; i-- {
+		fillUnset(job, chain[i])
+	}
+}
+
+func fillUnset(job *JobSpec, tmpl *JobSpec) {
+	dst := reflect.ValueOf(job).Elem()
+	src := reflect.ValueOf(tmpl).Elem()
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		switch t.Field(i).Name {
+		case "Name", "Template", "SourceFile", "ApiDefined":
+			continue
+		}
+		field := dst.Field(i)
+		if !field.IsZero() || src.Field(i).IsZero() {
+			continue
+		}
+		if field.Kind() == reflect.Slice {
+			// Copy, so jobs sharing a template don't share its lists
+			field.Set(reflect.AppendSlice(reflect.MakeSlice(field.Type(), 0, src.Field(i).Len()), src.Field(i)))
+		} else {
+			field.Set(src.Field(i))
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/gcfg.v1"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Read one config file into cfg. Files ending in .toml are in the structured
+// format, anything else is ini
+func readFile(cfg *Config, fn string) error {
+	if strings.HasSuffix(strings.ToLower(fn), ".toml") {
+		return readToml(cfg, fn)
+	}
+	return gcfg.ReadFileInto(cfg, fn)
+}
+
+// Read a TOML config. Keys are the same as in ini configs. Named sections are
+// tables of tables ([job.backup]), multi-valued keys are arrays, and a job's
+// commands may be tables holding per-command options
+func readToml(cfg *Config, fn string) error {
+	var doc map[string]interface{}
+	if _, err := toml.DecodeFile(fn, &doc); err != nil {
+		return errors.New(fmt.Sprintf("%s: %s", fn, err.Error()))
+	}
+	v := reflect.ValueOf(cfg).Elem()
+	for _, kind := range sortedKeys(doc) {
+		field := fieldForKey(v.Type(), kind)
+		if field == nil {
+			return errors.New(fmt.Sprintf("%s: unknown section %q", fn, kind))
+		}
+		values, ok := doc[kind].(map[string]interface{})
+		if !ok {
+			return errors.New(fmt.Sprintf("%s: %s must be a table", fn, kind))
+		}
+		section_v := v.FieldByIndex(field.Index)
+		if section_v.Kind() == reflect.Struct {
+			if err := setFields(section_v, values); err != nil {
+				return errors.New(fmt.Sprintf("%s: [%s] %s", fn, kind, err.Error()))
+			}
+			continue
+		}
+		if section_v.IsNil() {
+			section_v.Set(reflect.MakeMap(section_v.Type()))
+		}
+		for _, name := range sortedKeys(values) {
+			named, ok := values[name].(map[string]interface{})
+			if !ok {
+				return errors.New(fmt.Sprintf("%s: %s.%s must be a table", fn, kind, name))
+			}
+			spec := reflect.New(section_v.Type().Elem().Elem())
+			if err := setFields(spec.Elem(), named); err != nil {
+				return errors.New(fmt.Sprintf("%s: [%s] %s", fn, section(kind, name), err.Error()))
+			}
+			section_v.SetMapIndex(reflect.ValueOf(name), spec)
+		}
+	}
+	return nil
+}
+
+// Whether a field can be set from a config file. Fields the loader fills in
+// itself cannot
+func settable(f reflect.StructField) bool {
+	switch f.Name {
+	case "Name", "SourceFile", "ApiDefined", "Tags", "JumpHosts":
+		return false
+	}
+	return f.PkgPath == "" && f.Tag.Get("json") != "-"
+}
+
+// Config key for a field: its gcfg tag, or else its lowercased name
+func configKey(f reflect.StructField) string {
+	if key := f.Tag.Get("gcfg"); key != "" {
+		return key
+	}
+	return strings.ToLower(f.Name)
+}
+
+func fieldForKey(t reflect.Type, key string) *reflect.StructField {
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); settable(f) && configKey(f) == key {
+			return &f
+		}
+	}
+	return nil
+}
+
+func setFields(v reflect.Value, values map[string]interface{}) error {
+	for _, key := range sortedKeys(values) {
+		if job, ok := v.Addr().Interface().(*JobSpec); ok && key == "command" {
+			if err := setCommands(job, values[key]); err != nil {
+				return err
+			}
+			continue
+		}
+		f := fieldForKey(v.Type(), key)
+		if f == nil {
+			return errors.New(fmt.Sprintf("unknown key %q", key))
+		}
+		if err := setValue(v.FieldByIndex(f.Index), values[key]); err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", key, err.Error()))
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value interface{}) error {
+	switch field.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			field.SetString(s)
+			return nil
+		}
+		return errors.New("expected a string")
+	case reflect.Int:
+		if i, ok := value.(int64); ok {
+			field.SetInt(i)
+			return nil
+		}
+		return errors.New("expected an integer")
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			field.SetBool(b)
+			return nil
+		}
+		return errors.New("expected true or false")
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setValue(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	case reflect.Slice:
+		if s, ok := value.(string); ok {
+			value = []interface{}{s} // A single value for a multi-valued key
+		}
+		if list, ok := value.([]interface{}); ok && field.Type().Elem().Kind() == reflect.String {
+			strs := make([]string, len(list))
+			for i, item := range list {
+				if strs[i], ok = item.(string); !ok {
+					return errors.New("expected a list of strings")
+				}
+			}
+			field.Set(reflect.ValueOf(strs))
+			return nil
+		}
+		return errors.New("expected a list of strings")
+	case reflect.Map:
+		if table, ok := value.(map[string]interface{}); ok && field.Type().Elem().Kind() == reflect.String {
+			m := make(map[string]string)
+			for k, item := range table {
+				if m[k], ok = item.(string); !ok {
+					return errors.New("expected a table of strings")
+				}
+			}
+			field.Set(reflect.ValueOf(m))
+			return nil
+		}
+		return errors.New("expected a table of strings")
+	}
+	return errors.New("not supported in config files")
+}
+
+// A job's commands: strings, or tables with a run key and per-command options
+func setCommands(job *JobSpec, value interface{}) error {
+	var tables []map[string]interface{}
+	with_options := true
+	switch commands := value.(type) {
+	case string:
+		job.Command = []string{commands}
+		return nil
+	case []interface{}:
+		with_options = false
+		for _, cmd := range commands {
+			if table, ok := cmd.(map[string]interface{}); ok {
+				tables = append(tables, table)
+				with_options = true
+			} else if s, ok := cmd.(string); ok {
+				tables = append(tables, map[string]interface{}{"run": s})
+			} else {
+				return errors.New("command: expected strings or tables")
+			}
+		}
+	case []map[string]interface{}:
+		tables = commands
+	default:
+		return errors.New("command: expected strings or tables")
+	}
+	job.Command = make([]string, len(tables))
+	job.CommandOptions = make([]CommandSpec, len(tables))
+	for i, table := range tables {
+		run, ok := table["run"].(string)
+		if !ok {
+			return errors.New(fmt.Sprintf("command %d: run must be a string", i+1))
+		}
+		job.Command[i] = run
+		delete(table, "run")
+		if err := setFields(reflect.ValueOf(&job.CommandOptions[i]).Elem(), table); err != nil {
+			return errors.New(fmt.Sprintf("command %d: %s", i+1, err.Error()))
+		}
+	}
+	if !with_options {
+		job.CommandOptions = nil // Plain strings, so keep the job like an ini one
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Convert an ini config file to TOML, as written. Included files are left
+// alone, and comments are lost
+func ConvertToToml(fn string, w io.Writer) error {
+	var cfg Config
+	if err := gcfg.ReadFileInto(&cfg, fn); err != nil {
+		return err
+	}
+	doc := make(map[string]interface{})
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		kind := strings.ToLower(f.Name)
+		section_v := v.Field(i)
+		if section_v.Kind() == reflect.Struct {
+			if values := tomlFields(section_v); len(values) > 0 {
+				doc[kind] = values
+			}
+			continue
+		}
+		if section_v.Len() == 0 {
+			continue
+		}
+		named := make(map[string]interface{})
+		for _, name := range section_v.MapKeys() {
+			named[name.String()] = tomlFields(section_v.MapIndex(name).Elem())
+		}
+		doc[kind] = named
+	}
+	fmt.Fprintf(w, "# Converted from %s\n\n", fn)
+	encoder := toml.NewEncoder(w)
+	encoder.Indent = ""
+	return encoder.Encode(doc)
+}
+
+// The set fields of a section, keyed by config key
+func tomlFields(v reflect.Value) map[string]interface{} {
+	values := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if f := v.Type().Field(i); settable(f) && !field.IsZero() {
+			if field.Kind() == reflect.Ptr {
+				field = field.Elem()
+			}
+			values[configKey(f)] = field.Interface()
+		}
+	}
+	return values
+}
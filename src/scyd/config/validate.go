@@ -0,0 +1,292 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"scyd/ssh"
+	"sort"
+	"strings"
+)
+
+// Pool modes a job may give after the pool name
+var pool_modes = map[string]bool{"": true, "roundrobin": true, "parallel": true}
+
+// A single config problem, located by section and key
+type Problem struct {
+	Section string // eg `job "backup"`. Empty for file-level problems
+	Key     string `json:",omitempty"`
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Section == "":
+		return p.Message
+	case p.Key == "":
+		return fmt.Sprintf("[%s]: %s", p.Section, p.Message)
+	}
+	return fmt.Sprintf("[%s] %s: %s", p.Section, p.Key, p.Message)
+}
+
+// Every problem found in a config
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("%d config problem(s):\n  %s", len(e.Problems), strings.Join(lines, "\n  "))
+}
+
+// Problems behind a config load error. Errors that are not validation errors
+// (an unreadable or unparseable file) become a single file-level problem
+func Problems(err error) []Problem {
+	if err == nil {
+		return []Problem{}
+	}
+	if ve, ok := err.(*ValidationError); ok {
+		return ve.Problems
+	}
+	return []Problem{{Message: err.Error()}}
+}
+
+type problemList []Problem
+
+func (l *problemList) add(section string, key string, format string, args ...interface{}) {
+	*l = append(*l, Problem{Section: section, Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l problemList) Len() int      { return len(l) }
+func (l problemList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l problemList) Less(i, j int) bool {
+	if l[i].Section != l[j].Section {
+		return l[i].Section < l[j].Section
+	}
+	if l[i].Key != l[j].Key {
+		return l[i].Key < l[j].Key
+	}
+	return l[i].Message < l[j].Message
+}
+
+func section(kind string, name string) string {
+	return fmt.Sprintf("%s %q", kind, name)
+}
+
+// Check a loaded config, returning a *ValidationError listing every problem.
+// Expects the defaults filled in by New
+func (cfg *Config) Validate() error {
+	problems := append(problemList{}, cfg.load_problems...)
+	cfg.validateGlobals(&problems)
+	for name, pool := range cfg.Pool {
+		s := section("pool", name)
+		for _, host := range pool.Host {
+			if _, _, err := ParsePoolHost(host); err != nil {
+				problems.add(s, "host", "%s", err.Error())
+			}
+		}
+		cfg.validatePoolSets(s, pool, &problems)
+		if pool.MaxConcurrent < 0 {
+			problems.add(s, "max-concurrent", "must not be negative (got %d)", pool.MaxConcurrent)
+		}
+		if !ssh.ValidHostKeyPolicy(pool.HostKeyPolicy) {
+			problems.add(s, "host-key-policy", "unknown policy %q", pool.HostKeyPolicy)
+		}
+		for _, source := range pool.Source {
+			if _, _, err := ParsePoolSource(source); err != nil {
+				problems.add(s, "source", "%s", err.Error())
+			}
+		}
+		if pool.RefreshInterval < 0 {
+			problems.add(s, "refresh-interval", "must not be negative (got %d)", pool.RefreshInterval)
+		}
+	}
+	for name, host := range cfg.Host {
+		if host.MaxConcurrent < 0 {
+			problems.add(section("host", name), "max-concurrent", "must not be negative (got %d)", host.MaxConcurrent)
+		}
+	}
+	for name, lock := range cfg.Lock {
+		if lock.Slots < 0 {
+			problems.add(section("lock", name), "slots", "must not be negative (got %d)", lock.Slots)
+		}
+	}
+	for name, notifier := range cfg.Notifier {
+		s := section("notifier", name)
+		if notifier.Path == "" {
+			problems.add(s, "path", "not set")
+			continue
+		}
+		stat, err := os.Stat(notifier.Path)
+		if err != nil {
+			problems.add(s, "path", "cannot stat %s (%s)", notifier.Path, err.Error())
+		} else if (stat.Mode() & 0111) == 0 {
+			problems.add(s, "path", "%s must be executable", notifier.Path)
+		}
+	}
+	for name, job := range cfg.Job {
+		cfg.validateJob(name, job, &problems)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Sort(problems)
+	return &ValidationError{Problems: problems}
+}
+
+func (cfg *Config) validateGlobals(problems *problemList) {
+	if cfg.General.MaxConcurrentRuns < 0 {
+		problems.add("general", "max-concurrent-runs", "must not be negative (got %d)", cfg.General.MaxConcurrentRuns)
+	}
+	c := cfg.Connections
+	if c.MaxSessions < 0 || c.IdleTimeout < 0 || c.KeepaliveInterval < 0 {
+		problems.add("connections", "", "max-sessions, idle-timeout and keepalive-interval must not be negative")
+	}
+	d := cfg.Defaults
+	if d.Notifier != "" && cfg.Notifier[d.Notifier] == nil {
+		problems.add("defaults", "notifier", "notifier %q does not exist", d.Notifier)
+	}
+	if !ssh.ValidHostKeyPolicy(d.HostKeyPolicy) {
+		problems.add("defaults", "host-key-policy", "unknown policy %q", d.HostKeyPolicy)
+	}
+	if d.ConnectRetries != nil && *d.ConnectRetries < 0 {
+		problems.add("defaults", "connect-retries", "must not be negative (got %d)", *d.ConnectRetries)
+	}
+	hops := cfg.jumpHosts(d.JumpHost)
+	for _, hop := range hops {
+		if _, err := ssh.ParseServerSpec(hop.Host); err != nil {
+			problems.add("defaults", "jump-host", "%s", err.Error())
+		}
+	}
+	defaults_job := &JobSpec{PassphraseFile: d.PassphraseFile, PassphraseEnv: d.PassphraseEnv}
+	passphrase, err := defaults_job.KeyPassphrase()
+	if err != nil {
+		problems.add("defaults", "passphrase", "%s", err.Error())
+		return
+	}
+	if _, err := ssh.LoadSigners(d.Keyfile, d.Certfile, passphrase); err != nil {
+		problems.add("defaults", "keyfile", "%s", err.Error())
+	}
+	for _, hop := range hops {
+		if _, err := ssh.LoadSigners(hop.Keyfile, nil, passphrase); err != nil {
+			problems.add("defaults", "jump-host", "key for %s: %s", hop.Host, err.Error())
+		}
+	}
+}
+
+func (cfg *Config) validatePoolSets(s string, pool *PoolSpec, problems *problemList) {
+	if pool.Select != "" {
+		if _, err := ParseSelector(pool.Select); err != nil {
+			problems.add(s, "select", "%s", err.Error())
+		}
+	}
+	for _, name := range nameList(pool.Union) {
+		if cfg.Pool[name] == nil {
+			problems.add(s, "union", "pool %q does not exist", name)
+		}
+	}
+	for _, name := range nameList(pool.Except) {
+		if cfg.Pool[name] == nil {
+			problems.add(s, "except", "pool %q does not exist", name)
+		}
+	}
+	if len(pool.Except) > 0 && pool.Select == "" && len(pool.Union) == 0 {
+		problems.add(s, "except", "needs select or union to take hosts from")
+	}
+}
+
+func (cfg *Config) validateJob(name string, job *JobSpec, problems *problemList) {
+	s := section("job", name)
+	if err := job.ParseSchedule(); err != nil {
+		problems.add(s, "schedule", "%s", err.Error())
+	}
+	if len(job.Command) == 0 {
+		problems.add(s, "command", "no commands given")
+	}
+	switch {
+	case job.Host == "" && job.Pool == "":
+		problems.add(s, "host", "neither host nor pool is set")
+	case job.Host != "":
+		if _, err := ssh.ParseServerSpec(job.Host); err != nil {
+			problems.add(s, "host", "%s", err.Error())
+		}
+	case job.PoolInst == nil:
+		problems.add(s, "pool", "pool %q does not exist", strings.TrimSpace(job.Pool))
+	}
+	if !pool_modes[job.PoolMode] {
+		problems.add(s, "pool", "unknown pool mode %q (expected roundrobin or parallel)", job.PoolMode)
+	}
+	if job.ConnectTimeout < 0 || job.ReadTimeout < 0 || job.IdleOutputTimeout < 0 {
+		problems.add(s, "", "connect-timeout, read-timeout and idle-output-timeout must not be negative")
+	}
+	for i, opts := range job.CommandOptions {
+		if opts.ReadTimeout < 0 {
+			problems.add(s, "command", "read-timeout for command %d must not be negative", i+1)
+		}
+	}
+	if job.Retries() < 0 {
+		problems.add(s, "connect-retries", "must not be negative (got %d)", job.Retries())
+	}
+	if job.ConnectRetryDelay < 0 {
+		problems.add(s, "connect-retry-delay", "must not be negative (got %d)", job.ConnectRetryDelay)
+	}
+	if !ssh.ValidHostKeyPolicy(job.HostKeyPolicy) {
+		problems.add(s, "host-key-policy", "unknown policy %q", job.HostKeyPolicy)
+	}
+	if job.HostCA != "" {
+		if _, err := ssh.MakeHostCACallback(job.HostCA, nil); err != nil {
+			problems.add(s, "host-ca", "%s", err.Error())
+		}
+	}
+	if job.Notifier != "" && cfg.Notifier[job.Notifier] == nil {
+		problems.add(s, "notifier", "notifier %q does not exist", job.Notifier)
+	}
+	// Default keys are checked once under [defaults], unless the job has its
+	// own passphrase for them
+	own_passphrase := job.PassphraseFile != cfg.Defaults.PassphraseFile || job.PassphraseEnv != cfg.Defaults.PassphraseEnv
+	if passphrase, err := job.KeyPassphrase(); err != nil {
+		if own_passphrase {
+			problems.add(s, "passphrase", "%s", err.Error())
+		}
+	} else {
+		if _, err := ssh.LoadSigners(job.Keyfile, job.Certfile, passphrase); err != nil {
+			problems.add(s, "keyfile", "%s", err.Error())
+		}
+		hops := job.JumpHosts
+		if own_passphrase {
+			if len(job.Keyfile) == 0 {
+				if _, err := ssh.LoadSigners(job.DefaultKeyfile, job.DefaultCertfile, passphrase); err != nil {
+					problems.add(s, "keyfile", "default key: %s", err.Error())
+				}
+			}
+			hops = append(hops, job.DefaultJumpHosts...)
+		}
+		for _, hop := range hops {
+			if _, err := ssh.LoadSigners(hop.Keyfile, nil, passphrase); err != nil {
+				problems.add(s, "jump-host", "key for %s: %s", hop.Host, err.Error())
+			}
+		}
+	}
+	for _, hop := range job.JumpHosts {
+		if _, err := ssh.ParseServerSpec(hop.Host); err != nil {
+			problems.add(s, "jump-host", "%s", err.Error())
+		}
+	}
+	if _, err := ssh.LoadSshConfig(job.SshConfig); err != nil {
+		problems.add(s, "ssh-config", "%s", err.Error())
+	}
+	if _, err := job.SuccessPercent(); err != nil {
+		problems.add(s, "success-threshold", "%s", err.Error())
+	}
+	if job.MinSuccesses < 0 {
+		problems.add(s, "min-successes", "must not be negative (got %d)", job.MinSuccesses)
+	}
+	if pool := job.PoolInst; pool != nil && !cfg.dynamicHosts(pool.Name, make(map[string]bool)) && job.PoolMode == "parallel" && job.MinSuccesses > len(pool.Host) {
+		problems.add(s, "min-successes", "%d is more than the %d host(s) in pool %q", job.MinSuccesses, len(pool.Host), pool.Name)
+	}
+	if job.LockPolicy != "wait" && job.LockPolicy != "skip" {
+		problems.add(s, "lock-policy", "unknown policy %q (expected wait or skip)", job.LockPolicy)
+	}
+}
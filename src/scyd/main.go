@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"os/user"
 	"scyd/config"
 	"scyd/scheduler"
@@ -41,6 +42,16 @@ func setUser(name string) (err error) {
 	return err
 }
 
+// Reload the config whenever we get a SIGHUP
+func reloadOnHangup(ctx web.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		log.Printf("Got SIGHUP. Reloading %s", ctx.CfgPath)
+		ctx.ReqChan <- scheduler.LoadConfigRequest{Path: ctx.CfgPath, Source: "SIGHUP"}
+	}
+}
+
 func main() {
 	var ctx web.Context
 	cfg_path := flag.String("config", "/etc/scylla.conf", "Config file path")
@@ -66,5 +77,7 @@ func main() {
 
 	ctx.ReqChan = scheduler.Run()
 	ctx.Config = *cfg
+	go reloadOnHangup(ctx)
+	go scheduler.WatchConfig(ctx.ReqChan)
 	web.Run(&ctx)
 }
@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"scyd/config"
+)
+
+// Create (POST), replace (PUT) or delete (DELETE) a job defined through the
+// API. Spec is the JSON JobSpec as submitted. A *ChangeJobResult is sent back
+type ChangeJobRequest struct {
+	Name   string
+	Method string
+	Spec   []byte
+	Chan   chan StatusResponse
+}
+
+type ChangeJobResult struct {
+	Applied  bool
+	Error    string           `json:",omitempty"`
+	Problems []config.Problem `json:",omitempty"`
+	Code     int              `json:"-"` // HTTP status for the API
+}
+
+func changeFailed(code int, format string, args ...interface{}) *ChangeJobResult {
+	return &ChangeJobResult{Code: code, Error: fmt.Sprintf(format, args...)}
+}
+
+// Apply a job change to the running config and jobs. Only API-defined jobs
+// may be changed; file-defined jobs are read-only
+func changeApiJob(req ChangeJobRequest, cfg *config.Config, jobs JobList, queue *RunQueue, locks *LockManager) *ChangeJobResult {
+	if cfg == nil {
+		return changeFailed(503, "No config loaded")
+	}
+	existing := cfg.Job[req.Name]
+	switch {
+	case req.Method == "POST" && existing != nil:
+		return changeFailed(409, "Job %s already exists", req.Name)
+	case req.Method != "POST" && existing == nil:
+		return changeFailed(404, "No such job: %s", req.Name)
+	case existing != nil && !existing.ApiDefined:
+		return changeFailed(403, "Job %s is defined in %s and can only be changed there", req.Name, existing.SourceFile)
+	}
+
+	if req.Method == "DELETE" {
+		if err := config.DeleteApiJob(req.Name); err != nil {
+			return changeFailed(500, "Unable to delete job: %s", err.Error())
+		}
+		log.Printf("Deleting API job: %s\n", req.Name)
+		removeJob(req.Name, jobs, queue, locks)
+		delete(cfg.Job, req.Name)
+		saveConfig(cfg)
+		return &ChangeJobResult{Applied: true, Code: 200}
+	}
+
+	var spec config.JobSpec
+	if err := json.Unmarshal(req.Spec, &spec); err != nil {
+		return changeFailed(400, "Unable to parse job: %s", err.Error())
+	}
+	spec.Name = req.Name
+	if err := cfg.ResolveApiJob(&spec); err != nil {
+		return &ChangeJobResult{Code: 400, Problems: config.Problems(err)}
+	}
+	// Create the job before saving it, and save it before changing anything, so
+	// a failure leaves neither a stray job file nor a job that isn't saved
+	result := &ChangeJobResult{Applied: true, Code: 200}
+	job := jobs[req.Name]
+	if job == nil {
+		var err error
+		if job, err = New(&spec); err != nil {
+			return changeFailed(500, "Unable to create job: %s", err.Error())
+		}
+		result.Code = 201
+	}
+	if err := config.SaveApiJob(req.Name, req.Spec); err != nil {
+		return changeFailed(500, "Unable to save job: %s", err.Error())
+	}
+	if jobs[req.Name] == nil {
+		log.Printf("Adding API job: %s\n", req.Name)
+		jobs[req.Name] = job
+	} else {
+		log.Printf("Updating API job: %s\n", req.Name)
+		job.update(&spec)
+	}
+	jobs[req.Name].save()
+	cfg.Job[req.Name] = &spec
+	saveConfig(cfg)
+	return result
+}
+
+// Drop a job that is no longer configured, along with its state file
+func removeJob(name string, jobs JobList, queue *RunQueue, locks *LockManager) {
+	queue.drop(name)
+	locks.cancel(name)
+	locks.release(name)
+	log.Printf("Removing old job file for %s\n", name)
+	os.Remove(filepath.Join(config.JobDir(), name+".json"))
+	delete(jobs, name)
+}
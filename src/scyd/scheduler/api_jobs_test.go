@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"scyd/config"
+	"testing"
+)
+
+func TestChangeApiJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scylla-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SCYLLA_PATH", dir)
+	defer os.Unsetenv("SCYLLA_PATH")
+
+	cfg := &config.Config{Job: map[string]*config.JobSpec{"from-file": {Name: "from-file", SourceFile: "scylla.conf"}}}
+	jobs := JobList{}
+	queue := NewRunQueue(nil)
+	locks := NewLockManager()
+	change := func(method string, name string, spec string) *ChangeJobResult {
+		return changeApiJob(ChangeJobRequest{Name: name, Method: method, Spec: []byte(spec)}, cfg, jobs, queue, locks)
+	}
+
+	spec := `{"Host": "localhost", "Command": ["true"], "Schedule": "cron 0 * * * *"}`
+	if result := change("POST", "hourly", spec); result.Code != 201 || jobs["hourly"] == nil || !cfg.Job["hourly"].ApiDefined {
+		t.Fatalf("Expected the job to be created, got %+v", result)
+	}
+	if _, err := os.Stat(config.ApiJobDir() + "/hourly.json"); err != nil {
+		t.Errorf("Expected the job to be saved: %s", err.Error())
+	}
+	if result := change("POST", "hourly", spec); result.Code != 409 {
+		t.Errorf("Expected a conflict creating an existing job, got %+v", result)
+	}
+	if result := change("PUT", "hourly", `{"Command": ["true"]}`); result.Code != 400 || len(result.Problems) == 0 {
+		t.Errorf("Expected problems for a job without a host, got %+v", result)
+	}
+	if result := change("PUT", "from-file", spec); result.Code != 403 {
+		t.Errorf("File-defined jobs should be read-only, got %+v", result)
+	}
+	if result := change("DELETE", "hourly", ""); result.Code != 200 || jobs["hourly"] != nil || cfg.Job["hourly"] != nil {
+		t.Errorf("Expected the job to be deleted, got %+v", result)
+	}
+	if result := change("DELETE", "hourly", ""); result.Code != 404 {
+		t.Errorf("Expected a deleted job to be missing, got %+v", result)
+	}
+
+	os.RemoveAll(config.ApiJobDir())
+	ioutil.WriteFile(config.ApiJobDir(), []byte("in the way"), 0644)
+	if result := change("POST", "unsaved", spec); result.Code != 500 || jobs["unsaved"] != nil || cfg.Job["unsaved"] != nil {
+		t.Errorf("A job that can't be saved should not be added, got %+v", result)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"scyd/config"
 	"scyd/ssh"
 	"sort"
@@ -18,8 +17,6 @@ import (
 	"time"
 )
 
-var host_parse = regexp.MustCompile(`^((?P<user>.+)@)?(?P<hostname>.+)`)
-
 type RunStatus int
 
 const (
@@ -29,6 +26,7 @@ const (
 	Failed
 	Cancelled
 	Abandoned
+	Queued
 )
 
 var RunStatusNames = []string{
@@ -38,6 +36,7 @@ var RunStatusNames = []string{
 	"failed",
 	"cancelled",
 	"abandoned",
+	"queued",
 }
 
 type Runner interface {
@@ -83,6 +82,27 @@ func New(spec *config.JobSpec) (*Job, error) {
 	return &job, err
 }
 
+// Older job state files stored Keyfile as a single string
+func upgradeJobJson(data []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data
+	}
+	var keyfile string
+	if json.Unmarshal(fields["Keyfile"], &keyfile) != nil {
+		return data
+	}
+	keyfiles := []string{}
+	if keyfile != "" {
+		keyfiles = append(keyfiles, keyfile)
+	}
+	fields["Keyfile"], _ = json.Marshal(keyfiles)
+	if upgraded, err := json.Marshal(fields); err == nil {
+		return upgraded
+	}
+	return data
+}
+
 func loadJob(path string) (job *Job, err error) {
 	var data []byte
 	var new_job Job
@@ -90,15 +110,20 @@ func loadJob(path string) (job *Job, err error) {
 	if data, err = ioutil.ReadFile(path); err != nil {
 		return job, err
 	}
+	data = upgradeJobJson(data)
 	if err := json.Unmarshal(data, job); err != nil {
 		return job, err
 	}
+	// Secrets were redacted when the job was saved
+	if err = job.Interpolate(); err != nil {
+		log.Printf("Unable to resolve saved job %s: %s\n", job.Name, err.Error())
+	}
 	// Schedule will be unparsed, so parse it for each job
 	err = job.ParseSchedule()
 	if err != nil {
 		return job, err
 	}
-	if job.Status == Running {
+	if job.Status == Running || job.Status == Queued {
 		job.Status = Abandoned
 	}
 	lower_bound := job.RunId - job.MaxRunHistory
@@ -118,8 +143,9 @@ func loadJob(path string) (job *Job, err error) {
 						run.Status = Abandoned
 					}
 					for i, _ := range run.HostRuns {
-						if run.HostRuns[i].Status == Running {
+						if run.HostRuns[i].Status == Running || run.HostRuns[i].Status == Queued {
 							run.HostRuns[i].Status = Abandoned
+							run.HostRuns[i].QueuePosition = 0
 						}
 					}
 					job.History = append(job.History, run)
@@ -147,7 +173,7 @@ func (job *Job) save() (err error) {
 	path := filepath.Join(config.JobDir(), job.Name+".json")
 	os.MkdirAll(config.JobDir(), 0755)
 	var b []byte
-	if b, err = json.Marshal(job); err == nil {
+	if b, err = config.RedactedJson(job); err == nil {
 		err = ioutil.WriteFile(path, b, 0644)
 	}
 	return err
@@ -158,7 +184,7 @@ func (job *Job) saveRun(run *JobRun) (err error) {
 	os.MkdirAll(run_dir, 0755)
 	path := filepath.Join(run_dir, "run.json")
 	var b []byte
-	if b, err = json.Marshal(run); err == nil {
+	if b, err = config.RedactedJson(run); err == nil {
 		err = ioutil.WriteFile(path, b, 0644)
 		if err != nil {
 			log.Printf("Unable to save job status: %s\n", err.Error())
@@ -202,10 +228,13 @@ func (job *Job) complete(r *HostRun, notifier *JobNotifier) bool {
 			job.History[i].HostRuns[j] = *r
 		}
 	}
-	job.History[i].updateStatus()
+	job.History[i].updateStatus(job.requiredSuccesses(len(job.History[i].HostRuns)))
 	if job.History[0].Status != Running {
 		job.Status = job.History[0].Status
 		log.Printf("Completed job %s.%d (%s)\n", job.Name, job.RunId, RunStatusNames[job.Status])
+		if job.History[0].Warning != "" {
+			log.Printf("WARNING: job %s.%d: %s\n", job.Name, job.RunId, job.History[0].Warning)
+		}
 		job.EndTime = time.Now()
 		job.save()
 		job.saveRun(&job.History[i])
@@ -217,6 +246,14 @@ func (job *Job) complete(r *HostRun, notifier *JobNotifier) bool {
 	return false
 }
 
+// Quorum rules only apply to parallel pool runs -- everything else must succeed everywhere
+func (job *Job) requiredSuccesses(hosts int) int {
+	if job.Host != "" || job.PoolMode != "parallel" {
+		return hosts
+	}
+	return job.RequiredSuccesses(hosts)
+}
+
 func (job *Job) getRunIndex(id int) (int, error) {
 	for idx, rh := range job.History {
 		if rh.RunId == id {
@@ -226,6 +263,20 @@ func (job *Job) getRunIndex(id int) (int, error) {
 	return 0, errors.New(fmt.Sprintf("run %d nost found", id))
 }
 
+// Update the status and queue position of a host run in the job history
+func (job *Job) markHostRun(run_id int, host_id int, status RunStatus, position int) {
+	i, err := job.getRunIndex(run_id)
+	if err != nil {
+		return
+	}
+	for j, hr := range job.History[i].HostRuns {
+		if hr.HostId == host_id {
+			job.History[i].HostRuns[j].Status = status
+			job.History[i].HostRuns[j].QueuePosition = position
+		}
+	}
+}
+
 func (job *Job) getRun(id string) *JobRun {
 	nid, err := strconv.Atoi(id)
 	if err != nil {
@@ -239,24 +290,104 @@ func (job *Job) getRun(id string) *JobRun {
 	return nil
 }
 
-func openConnection(keyfile string, host string, timeout int) (Runner, error) {
-	auths := ssh.MakeKeyring([]string{keyfile})
+// Connect to a host, retrying as the job's retry policy allows, through the
+// client pool if it is enabled. Failed attempts are returned even when a later
+// attempt succeeds
+func openConnection(spec *config.JobSpec, host string, clients *ssh.ClientPool) (Runner, []ConnectAttempt, error) {
+	var attempts []ConnectAttempt
+	passphrase, err := spec.KeyPassphrase()
+	if err != nil {
+		return nil, attempts, err
+	}
+	ssh_config, err := ssh.LoadSshConfig(spec.SshConfig)
+	if err != nil {
+		return nil, attempts, err
+	}
+	hc := ssh_config.Lookup(config.HostName(host))
+	keyfiles, certfiles := spec.Keys(hc.IdentityFile)
+	auths, err := ssh.MakeAuths(keyfiles, certfiles, passphrase)
+	if err != nil {
+		return nil, attempts, err
+	}
 	var c ssh.SshConnection
-	var err error
-	for i := 0; i < 8; i++ {
-		err = c.Open(host, auths, timeout)
+	c.SshConfig = ssh_config
+	// Explicit jump hosts win over ProxyJump in the ssh config, which wins over defaults
+	jump_hosts := spec.JumpHosts
+	if len(jump_hosts) == 0 && hc.ProxyJump != "" {
+		for _, hop := range strings.Split(hc.ProxyJump, ",") {
+			jump_hosts = append(jump_hosts, config.JumpSpec{Host: strings.TrimSpace(hop), Keyfile: ssh_config.Lookup(config.HostName(hop)).IdentityFile})
+		}
+	}
+	if len(jump_hosts) == 0 {
+		jump_hosts = spec.DefaultJumpHosts
+	}
+	for _, hop := range jump_hosts {
+		hop_auths, err := ssh.MakeAuths(hop.Keyfile, nil, passphrase)
 		if err != nil {
-			log.Printf("WARNING: Connection failed (%s). Weill retry (%d)", err.Error(), i)
-			time.Sleep(2 * time.Second)
-		} else {
-			break
+			return nil, attempts, err
 		}
-
+		c.JumpHosts = append(c.JumpHosts, ssh.JumpHost{Server: qualifyHost(hop.Host, spec.DefaultUser, ssh_config), Auths: hop_auths})
 	}
+	c.UseAgent = spec.UsesAgent()
+	c.CommandOptions = commandOptions(spec)
+	c.AgentForwarding = spec.AgentForwarding
+	c.HostKeyCallback, err = ssh.MakeHostKeyCallback(spec.HostKeyPolicy, spec.KnownHosts, config.KnownHostsPath())
 	if err != nil {
-		return nil, err
+		return nil, attempts, err
+	}
+	if spec.HostCA != "" {
+		if c.HostKeyCallback, err = ssh.MakeHostCACallback(spec.HostCA, c.HostKeyCallback); err != nil {
+			return nil, attempts, err
+		}
+	}
+	dial := func() (*ssh.SshConnection, error) {
+		var err error
+		retries := spec.Retries()
+		for i := 0; i <= retries; i++ {
+			err = c.Open(host, auths, spec.ConnectTimeout)
+			if err == nil {
+				break
+			}
+			attempts = append(attempts, ConnectAttempt{Time: time.Now(), Error: err.Error()})
+			if _, bad_key := err.(*ssh.HostKeyError); bad_key {
+				break // No point retrying
+			}
+			if i < retries {
+				delay := spec.RetryDelay(i)
+				log.Printf("WARNING: Connection to %s failed (%s). Will retry in %s (%d of %d)", host, err.Error(), delay, i+1, retries)
+				time.Sleep(delay)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &c, err
+	}
+	if clients == nil || !clients.Enabled() {
+		conn, err := dial()
+		if err != nil {
+			return nil, attempts, err
+		}
+		return conn, attempts, nil
+	}
+	// Pool key covers everything that went into the connection
+	key := fmt.Sprintf("%s %v %v %+v %t %t %s %s %s %s", host, keyfiles, certfiles, jump_hosts,
+		spec.UsesAgent(), spec.AgentForwarding, spec.HostKeyPolicy, spec.KnownHosts, spec.HostCA, spec.SshConfig)
+	conn, err := clients.Get(key, host, dial)
+	if err != nil {
+		return nil, attempts, err
+	}
+	conn.CommandOptions = commandOptions(spec)
+	return conn, attempts, nil
+}
+
+func commandOptions(spec *config.JobSpec) ssh.CommandOptions {
+	return ssh.CommandOptions{
+		SudoCommand: spec.SudoCommand,
+		SudoUser:    spec.SudoUser,
+		IdleTimeout: spec.IdleOutputTimeout,
+		Keepalive:   spec.KeepaliveInterval,
 	}
-	return &c, err
 }
 
 func (job *Job) hostRuns() []HostRun {
@@ -287,8 +418,9 @@ func (job *Job) hostRuns() []HostRun {
 	return runs
 }
 
-func (job *Job) run(run_report_chan chan HostRun) {
+func (job *Job) run(queue *RunQueue, locks *LockManager) {
 	if job.Host == "" && job.PoolInst != nil && len(job.PoolInst.Host) == 0 {
+		locks.cancel(job.Name)
 		return // No hosts to run on -- just bail
 	}
 	if job.Status == Running {
@@ -298,6 +430,14 @@ func (job *Job) run(run_report_chan chan HostRun) {
 		job.RunsOutstanding += 1
 		return
 	}
+	if !locks.acquire(job.Name, job.Lock) {
+		if job.LockPolicy == "skip" {
+			log.Printf("WARNING: will skip job %s. Locks %v are held.", job.Name, job.Lock)
+		} else if locks.wait(job.Name, job.Lock) {
+			log.Printf("Job %s waiting for locks %v", job.Name, job.Lock)
+		}
+		return
+	}
 	job.RunsOutstanding = 0
 	job.StartTime = time.Now()
 	job.Status = Running
@@ -312,39 +452,43 @@ func (job *Job) run(run_report_chan chan HostRun) {
 		old_run, job.History = job.History[l-1], job.History[:l-1]
 		cleanHistory(job.Name, old_run.RunId)
 	}
-	sudo := job.Sudo
-	keyfile := job.Keyfile
-	connection_timeout := job.ConnectTimeout
-	read_timeout := job.ReadTimeout
+	spec := job.JobSpec
+	ssh_config, err := ssh.LoadSshConfig(job.SshConfig)
+	if err != nil {
+		log.Printf("WARNING: Unable to load ssh config for job %s: %s", job.Name, err.Error())
+	}
 	run_dir := filepath.Join(config.JobDir(), job.Name, strconv.Itoa(job.RunId))
 	job.saveRun(&job_run)
+	pool := ""
+	if job.Host == "" && job.PoolInst != nil {
+		pool = job.PoolInst.Name
+	}
 	for _, run := range runs {
-		run.Status = Running
-		run.Host = qualifyHost(run.Host, job.DefaultUser)
-		go runCommandsOnHost(run, sudo, keyfile, connection_timeout, read_timeout, run_dir, run_report_chan)
+		run.Status = Queued
+		run.Host = qualifyHost(run.Host, job.DefaultUser, ssh_config)
+		queue.submit(run, pool, func(hr HostRun) {
+			go runCommandsOnHost(hr, spec, run_dir, queue.ReportChan, queue.Clients)
+		})
 	}
 }
 
 // Run command set on single remote host
 func runCommandsOnHost(
 	hr HostRun,
-	sudo bool,
-	keyfile string,
-	connection_timeout int,
-	read_timeout int,
+	spec config.JobSpec,
 	run_dir string,
-	run_report_chan chan HostRun) {
+	run_report_chan chan HostRun,
+	clients *ssh.ClientPool) {
 	hr.StartTime = time.Now()
 	hr.Status = Running
 	var conn Runner
 	var err error
-	parts := strings.Split(hr.Host, "@")
-	if len(parts) == 2 && parts[1] == "local" {
+	if server, _ := ssh.ParseServerSpec(hr.Host); server.IsLocal() {
 		log.Printf("Running local command...")
-		conn = NewLocalRunner() // This is a local run via exec()
+		conn = NewLocalRunner(spec.SudoCommand, spec.SudoUser, spec.IdleOutputTimeout) // This is a local run via exec()
 	} else {
 		log.Printf("Running remote command on [%s]", hr.Host)
-		conn, err = openConnection(keyfile, hr.Host, connection_timeout)
+		conn, hr.ConnectAttempts, err = openConnection(&spec, hr.Host, clients)
 	}
 	if err != nil {
 		hr.CommandRuns[0].Error = err.Error() // Just set first command to error on a failed connection
@@ -370,6 +514,7 @@ func runCommandsOnHost(
 			}
 			defer stderr_f.Close()
 
+			sudo, read_timeout := spec.CommandSettings(index)
 			err = conn.RunWithWriters(report.CommandSpecified, read_timeout, sudo, stdout_f, stderr_f)
 			if err != nil {
 				hr.CommandRuns[index].Error = err.Error()
@@ -395,35 +540,19 @@ func runCommandsOnHost(
 	run_report_chan <- hr
 }
 
-func qualifyHost(unqualified string, default_user string) (qualified string) {
-	m := FindNamedStringCaptures(host_parse, unqualified)
-	if m == nil {
-		return unqualified
-	}
-
-	host := m["hostname"]
-	user := m["user"]
-
-	if user == "" {
-		user = default_user
+// Add a user to a host. An explicit user wins over the ssh config, which wins over the default
+func qualifyHost(unqualified string, default_user string, ssh_config *ssh.SshConfig) (qualified string) {
+	spec, err := ssh.ParseServerSpec(unqualified)
+	if err != nil {
+		return unqualified // Rejected by config load and the pool api, so not expected
 	}
-
-	return fmt.Sprintf("%s@%s", user, host)
-}
-
-func FindNamedStringCaptures(re *regexp.Regexp, x string) map[string]string {
-	matches := make(map[string]string)
-	parts := re.FindStringSubmatch(x)
-	if parts == nil {
-		return nil
+	if spec.User == "" {
+		spec.User = ssh_config.Lookup(spec.Host).User
 	}
-
-	for index, key := range re.SubexpNames() {
-		if key != "" {
-			matches[key] = parts[index]
-		}
+	if spec.User == "" {
+		spec.User = default_user
 	}
-	return matches
+	return spec.String()
 }
 
 //
@@ -1 +1,63 @@
 package scheduler
+
+import (
+	"scyd/config"
+	"testing"
+)
+
+func mkRun(statuses ...RunStatus) JobRun {
+	run := JobRun{HostRuns: make([]HostRun, len(statuses))}
+	run.Status = Running
+	for i, s := range statuses {
+		run.HostRuns[i].HostId = i
+		run.HostRuns[i].Status = s
+	}
+	return run
+}
+
+func TestUpdateStatusWaitsForAllHosts(t *testing.T) {
+	run := mkRun(Failed, Running, Succeeded)
+	run.updateStatus(3)
+	if run.Status != Running {
+		t.Errorf("Run should still be running, got %s", RunStatusNames[run.Status])
+	}
+	run.HostRuns[1].Status = Succeeded
+	run.updateStatus(3)
+	if run.Status != Failed {
+		t.Errorf("Run should have failed, got %s", RunStatusNames[run.Status])
+	}
+}
+
+func TestUpdateStatusQuorum(t *testing.T) {
+	run := mkRun(Succeeded, Succeeded, Succeeded, Succeeded, Failed)
+	run.updateStatus(4)
+	if run.Status != Succeeded {
+		t.Errorf("Run should have succeeded, got %s", RunStatusNames[run.Status])
+	}
+	if run.Warning == "" {
+		t.Error("Expected a warning for the failed host")
+	}
+	run = mkRun(Succeeded, Succeeded, Succeeded, Failed, Failed)
+	run.updateStatus(4)
+	if run.Status != Failed {
+		t.Errorf("Run should have failed, got %s", RunStatusNames[run.Status])
+	}
+}
+
+func TestRequiredSuccesses(t *testing.T) {
+	job := Job{JobSpec: config.JobSpec{Pool: "web parallel", PoolMode: "parallel", SuccessThreshold: "80%"}}
+	if n := job.requiredSuccesses(50); n != 40 {
+		t.Errorf("Expected 40 required successes, got %d", n)
+	}
+	job.MinSuccesses = 45
+	if n := job.requiredSuccesses(50); n != 45 {
+		t.Errorf("Expected 45 required successes, got %d", n)
+	}
+	if n := job.requiredSuccesses(30); n != 30 {
+		t.Errorf("A pool smaller than min-successes should need every host, got %d", n)
+	}
+	job.PoolMode = ""
+	if n := job.requiredSuccesses(1); n != 1 {
+		t.Errorf("Round-robin runs should require every host, got %d", n)
+	}
+}
@@ -1,30 +1,70 @@
 package scheduler
 
 import (
-	"context"
 	"io"
 	"os/exec"
+	"scyd/ssh"
+	"syscall"
 	"time"
 )
 
 type LocalRunner struct {
+	SudoCommand string
+	SudoUser    string
+	IdleTimeout int // Seconds a command may go without output. 0 for never
 }
 
-func NewLocalRunner() (l *LocalRunner) {
-	return &LocalRunner{}
+func NewLocalRunner(sudo_command string, sudo_user string, idle_timeout int) (l *LocalRunner) {
+	return &LocalRunner{SudoCommand: sudo_command, SudoUser: sudo_user, IdleTimeout: idle_timeout}
 }
 
 func (l *LocalRunner) Close() {
 	// Does nothing, but required by scheduler
 }
 
+// Run a command, killing it (and anything it started) if it runs longer than
+// timeout or is silent for IdleTimeout seconds, as remote commands are
 func (l *LocalRunner) RunWithWriters(commandLine string, timeout int, sudo bool, stdout_f io.Writer, stderr_f io.Writer) (err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
+	if sudo {
+		commandLine = ssh.SudoCommandLine(l.SudoCommand, l.SudoUser, commandLine)
+	}
 	shell_command := []string{"/bin/bash", "-c", commandLine}
-	command := exec.CommandContext(ctx, shell_command[0], shell_command[1:]...)
-	command.Stdout = stdout_f
-	command.Stderr = stderr_f
-	err = command.Run()
-	return err
+	command := exec.Command(shell_command[0], shell_command[1:]...)
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	activity := ssh.NewOutputActivity()
+	command.Stdout = activity.Writer(stdout_f)
+	command.Stderr = activity.Writer(stderr_f)
+	if err = command.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+	kill := func() {
+		syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+		<-done
+	}
+	var max_runtime <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		defer timer.Stop()
+		max_runtime = timer.C
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err = <-done:
+			return err
+		case <-max_runtime:
+			kill()
+			return &ssh.CommandTimeoutError{Seconds: timeout}
+		case now := <-ticker.C:
+			if l.IdleTimeout > 0 && activity.Since(now) >= time.Duration(l.IdleTimeout)*time.Second {
+				kill()
+				return &ssh.CommandTimeoutError{Seconds: l.IdleTimeout, Idle: true}
+			}
+		}
+	}
 }
@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"bytes"
+	"scyd/ssh"
+	"testing"
+	"time"
+)
+
+func TestLocalTimeouts(t *testing.T) {
+	runner := NewLocalRunner("", "", 1)
+	var stdout, stderr bytes.Buffer
+	err := runner.RunWithWriters("for i in 1 2 3 4; do echo $i; sleep 0.4; done", 10, false, &stdout, &stderr)
+	if err != nil || stdout.String() != "1\n2\n3\n4\n" {
+		t.Errorf("A chatty command should not be killed, got %q (%v)", stdout.String(), err)
+	}
+
+	start := time.Now()
+	err = runner.RunWithWriters("echo started; sleep 30; echo never", 60, false, &stdout, &stderr)
+	if te, ok := err.(*ssh.CommandTimeoutError); !ok || !te.Idle {
+		t.Errorf("Expected an idle-output timeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Silent command took %s to kill", time.Since(start))
+	}
+
+	runner.IdleTimeout = 0
+	err = runner.RunWithWriters("while true; do echo tick; sleep 0.2; done", 1, false, &stdout, &stderr)
+	if te, ok := err.(*ssh.CommandTimeoutError); !ok || te.Idle {
+		t.Errorf("Expected the read-timeout, got %v", err)
+	}
+}
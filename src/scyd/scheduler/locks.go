@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"scyd/config"
+	"sort"
+)
+
+// Lock state reported by the locks api
+type LockReport struct {
+	Name    string
+	Slots   int
+	Holders []string
+	Waiters []string
+}
+
+type LocksByName []LockReport
+
+func (slice LocksByName) Len() int           { return len(slice) }
+func (slice LocksByName) Less(i, j int) bool { return slice[i].Name < slice[j].Name }
+func (slice LocksByName) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+
+// Named counting semaphores shared across jobs. A job holds all of its locks
+// for the duration of a run. Owned by the scheduler goroutine
+type LockManager struct {
+	slots   map[string]int
+	holders map[string][]string // lock name -> holding jobs
+	held    map[string][]string // job name -> held locks
+	waiters []string            // job names, in arrival order
+	wanted  map[string][]string // waiting job name -> locks it wants
+}
+
+func NewLockManager() *LockManager {
+	return &LockManager{
+		slots:   make(map[string]int),
+		holders: make(map[string][]string),
+		held:    make(map[string][]string),
+		wanted:  make(map[string][]string),
+	}
+}
+
+// Set slot counts from config. Locks not declared in config are simple mutexes
+func (l *LockManager) configure(cfg *config.Config) {
+	l.slots = make(map[string]int)
+	for name, lock := range cfg.Lock {
+		l.slots[name] = lock.Slots
+	}
+}
+
+func (l *LockManager) slotCount(name string) int {
+	if n := l.slots[name]; n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Take every named lock for a job, or none of them. Returns true if the job holds the locks
+func (l *LockManager) acquire(job string, names []string) bool {
+	if len(names) == 0 || l.held[job] != nil {
+		return true
+	}
+	for _, name := range names {
+		if len(l.holders[name]) >= l.slotCount(name) {
+			return false
+		}
+	}
+	for _, name := range names {
+		l.holders[name] = append(l.holders[name], job)
+	}
+	l.held[job] = names
+	l.cancel(job)
+	return true
+}
+
+// Release every lock held by a job
+func (l *LockManager) release(job string) {
+	for _, name := range l.held[job] {
+		holders := l.holders[name][:0]
+		for _, h := range l.holders[name] {
+			if h != job {
+				holders = append(holders, h)
+			}
+		}
+		if len(holders) == 0 {
+			delete(l.holders, name)
+		} else {
+			l.holders[name] = holders
+		}
+	}
+	delete(l.held, job)
+}
+
+// Queue a job until its locks are free. A job that is already waiting keeps its
+// place. Returns true if the job was newly queued
+func (l *LockManager) wait(job string, names []string) bool {
+	queued := l.wanted[job] == nil
+	if queued {
+		l.waiters = append(l.waiters, job)
+	}
+	l.wanted[job] = names
+	return queued
+}
+
+// Stop waiting for locks
+func (l *LockManager) cancel(job string) {
+	if l.wanted[job] == nil {
+		return
+	}
+	delete(l.wanted, job)
+	for i, name := range l.waiters {
+		if name == job {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			break
+		}
+	}
+}
+
+// Copy of the waiting job names, in arrival order
+func (l *LockManager) waiting() []string {
+	return append([]string{}, l.waiters...)
+}
+
+func (l *LockManager) report() []LockReport {
+	names := make(map[string]bool)
+	for name, _ := range l.slots {
+		names[name] = true
+	}
+	for name, _ := range l.holders {
+		names[name] = true
+	}
+	for _, wanted := range l.wanted {
+		for _, name := range wanted {
+			names[name] = true
+		}
+	}
+	data := make([]LockReport, 0, len(names))
+	for name, _ := range names {
+		lr := LockReport{Name: name, Slots: l.slotCount(name), Holders: append([]string{}, l.holders[name]...), Waiters: []string{}}
+		for _, job := range l.waiters {
+			for _, w := range l.wanted[job] {
+				if w == name {
+					lr.Waiters = append(lr.Waiters, job)
+				}
+			}
+		}
+		data = append(data, lr)
+	}
+	sort.Sort(LocksByName(data))
+	return data
+}
@@ -0,0 +1,31 @@
+package scheduler
+
+import (
+	"scyd/config"
+	"testing"
+)
+
+func TestLockManager(t *testing.T) {
+	locks := NewLockManager()
+	locks.configure(&config.Config{Lock: map[string]*config.LockSpec{"reports": {Name: "reports", Slots: 2}}})
+	if !locks.acquire("a", []string{"db", "reports"}) {
+		t.Error("a should have acquired its locks")
+	}
+	if locks.acquire("b", []string{"db"}) {
+		t.Error("b should not acquire a held mutex")
+	}
+	locks.wait("b", []string{"db"})
+	if !locks.acquire("c", []string{"reports"}) {
+		t.Error("c should have acquired the second reports slot")
+	}
+	if locks.acquire("d", []string{"reports"}) {
+		t.Error("d should not acquire a full semaphore")
+	}
+	locks.release("a")
+	if w := locks.waiting(); len(w) != 1 || w[0] != "b" {
+		t.Errorf("Expected b to be waiting, got %v", w)
+	}
+	if !locks.acquire("b", []string{"db"}) || len(locks.waiting()) != 0 {
+		t.Error("b should have acquired db and stopped waiting")
+	}
+}
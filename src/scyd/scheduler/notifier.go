@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"log"
+	"os"
 	"os/exec"
 	"scyd/config"
 	"strconv"
@@ -43,6 +44,12 @@ func (notifier JobNotifier) fireNotification(job *Job) {
 	args[2] = strconv.Itoa(job.History[0].RunId)
 	args = append(args, notifier.Args...)
 	cmd := exec.Command(notifier.Path, args...)
+	if len(notifier.Env) > 0 {
+		cmd.Env = os.Environ()
+		for name, value := range notifier.Env {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
 	log.Printf("Firing notification command %s %v", notifier.Path, args)
 	go cmd.Run()
 }
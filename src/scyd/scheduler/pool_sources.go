@@ -0,0 +1,182 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os/exec"
+	"scyd/config"
+	"scyd/ssh"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Longest an exec: pool source may run
+const POOL_SOURCE_TIMEOUT = 60 * time.Second
+
+// Refresh state of a pool with sources
+type PoolRefresh struct {
+	Sources     []string
+	Interval    time.Duration `json:"-"`
+	Running     bool
+	LastRefresh time.Time
+	LastError   string
+	NextRefresh time.Time
+}
+
+// Sent back to the scheduler by a finished refresh
+type PoolRefreshedRequest struct {
+	Name    string
+	Sources []string // Those refreshed, to spot results made stale by a reload
+	Hosts   []string
+	Error   string
+}
+
+// Pools with sources, by name
+type PoolRefresher map[string]*PoolRefresh
+
+// Track the pools in a new config. Pools whose sources are unchanged keep
+// their schedule, and new ones are refreshed straight away
+func (refresher PoolRefresher) configure(cfg *config.Config) {
+	for name, refresh := range refresher {
+		pool := cfg.Pool[name]
+		if pool == nil || strings.Join(pool.Source, "\n") != strings.Join(refresh.Sources, "\n") {
+			delete(refresher, name)
+		}
+	}
+	for name, pool := range cfg.Pool {
+		if len(pool.Source) == 0 {
+			continue
+		}
+		if refresher[name] == nil {
+			refresher[name] = &PoolRefresh{Sources: pool.Source}
+		}
+		refresher[name].Interval = time.Duration(pool.RefreshInterval) * time.Second
+	}
+}
+
+// Start a refresh of every pool that is due. Results come back to the
+// scheduler as PoolRefreshedRequests
+func (refresher PoolRefresher) start(request_chan chan Request) {
+	now := time.Now()
+	for name, refresh := range refresher {
+		if refresh.Running || now.Before(refresh.NextRefresh) {
+			continue
+		}
+		refresh.Running = true
+		go func(name string, sources []string) {
+			hosts, err := discoverHosts(sources)
+			result := PoolRefreshedRequest{Name: name, Sources: sources, Hosts: hosts}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			request_chan <- result
+		}(name, refresh.Sources)
+	}
+}
+
+// Record a finished refresh. Returns false if the pool has gone, its sources
+// have changed since the refresh started or the refresh failed, in which case
+// its hosts should be left alone
+func (refresher PoolRefresher) finished(req PoolRefreshedRequest) bool {
+	refresh := refresher[req.Name]
+	if refresh == nil {
+		return false
+	}
+	if strings.Join(req.Sources, "\n") != strings.Join(refresh.Sources, "\n") {
+		log.Printf("Dropping refresh of pool %s from its old sources", req.Name)
+		return false
+	}
+	refresh.Running = false
+	refresh.LastRefresh = time.Now()
+	refresh.LastError = req.Error
+	refresh.NextRefresh = refresh.LastRefresh.Add(refresh.Interval)
+	return req.Error == ""
+}
+
+// Host entries, with any tags, from all of a pool's sources, in order and
+// without duplicates. Fails if any source fails or none of them lists a host
+func discoverHosts(sources []string) ([]string, error) {
+	hosts := []string{}
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		found, err := readPoolSource(source)
+		if err != nil {
+			return nil, errors.New(source + ": " + err.Error())
+		}
+		for _, entry := range found {
+			host, tags, err := config.ParsePoolHost(entry)
+			if err != nil {
+				return nil, errors.New(source + ": " + err.Error())
+			}
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, config.PoolHostEntry(host, tags))
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts found")
+	}
+	return hosts, nil
+}
+
+func readPoolSource(source string) ([]string, error) {
+	kind, arg, err := config.ParsePoolSource(source)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "file":
+		data, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return hostLines(data), nil
+	case "exec":
+		ctx, cancel := context.WithTimeout(context.Background(), POOL_SOURCE_TIMEOUT)
+		defer cancel()
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", arg)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, errors.New(strings.TrimSpace(err.Error() + " " + stderr.String()))
+		}
+		return hostLines(out), nil
+	}
+	_, records, err := net.LookupSRV("", "", arg)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{}
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if port := strconv.Itoa(int(srv.Port)); port != ssh.DEFAULT_PORT {
+			host = net.JoinHostPort(host, port)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// One host per line. Blank lines and # comments are skipped
+func hostLines(data []byte) []string {
+	hosts := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			hosts = append(hosts, line)
+		}
+	}
+	return hosts
+}
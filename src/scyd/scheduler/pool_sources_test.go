@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"scyd/config"
+	"testing"
+)
+
+func TestDiscoverHosts(t *testing.T) {
+	f, err := ioutil.TempFile("", "scylla-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# web servers\nweb-1\n\nweb-2:2222 # moved\n")
+	f.Close()
+
+	hosts, err := discoverHosts([]string{"file:" + f.Name(), "exec:echo web-1; echo web-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 3 || hosts[0] != "web-1" || hosts[1] != "web-2:2222" || hosts[2] != "web-3" {
+		t.Errorf("Expected hosts from both sources without duplicates, got %v", hosts)
+	}
+	if _, err := discoverHosts([]string{"exec:exit 1"}); err == nil {
+		t.Error("Expected a failing command to fail the refresh")
+	}
+	if _, err := discoverHosts([]string{"exec:true"}); err == nil {
+		t.Error("Expected a source without hosts to fail the refresh")
+	}
+}
+
+func TestPoolRefresher(t *testing.T) {
+	cfg := &config.Config{Pool: map[string]*config.PoolSpec{
+		"web":    {Name: "web", Source: []string{"exec:echo web-1"}, RefreshInterval: 60},
+		"static": {Name: "static", Host: []string{"db-1"}},
+	}}
+	refresher := PoolRefresher{}
+	refresher.configure(cfg)
+	if len(refresher) != 1 || refresher["web"] == nil {
+		t.Fatalf("Expected only the pool with sources, got %v", refresher)
+	}
+	request_chan := make(chan Request)
+	refresher.start(request_chan)
+	req := (<-request_chan).(PoolRefreshedRequest)
+	if !refresher.finished(req) || len(req.Hosts) != 1 || req.Hosts[0] != "web-1" {
+		t.Errorf("Expected a successful refresh, got %+v", req)
+	}
+	refresher.start(request_chan) // Not due again for a minute, so nothing is sent
+	if refresher["web"].Running {
+		t.Error("Pool should not be refreshed again before its interval")
+	}
+	if refresher.finished(PoolRefreshedRequest{Name: "web", Sources: req.Sources, Error: "broken"}) || refresher["web"].LastError != "broken" {
+		t.Error("Failed refresh should be recorded and not applied")
+	}
+	cfg.Pool["web"].Source = []string{"file:/etc/hosts.d/web.txt"}
+	refresher.configure(cfg)
+	if refresher["web"].LastError != "" || !refresher["web"].LastRefresh.IsZero() {
+		t.Error("Changed sources should start afresh")
+	}
+	// A refresh from the old sources that was in flight during the reload
+	refresher["web"].Running = true
+	if refresher.finished(PoolRefreshedRequest{Name: "web", Sources: req.Sources, Hosts: req.Hosts}) || !refresher["web"].Running {
+		t.Error("Results from the old sources should be dropped")
+	}
+}
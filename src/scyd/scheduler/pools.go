@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"scyd/config"
+	"sort"
+	"time"
+)
+
+// Hosts last pushed to a dynamic pool. Kept under config.PoolCacheDir() so
+// that they survive restarts
+type DynamicPool struct {
+	Name      string
+	Hosts     []string
+	UpdatedAt time.Time
+}
+
+// Current membership of a pool
+type PoolReport struct {
+	Name      string
+	Dynamic   bool
+	Hosts     []string
+	Tags      map[string]map[string]string `json:",omitempty"`
+	UpdatedAt *time.Time                   `json:",omitempty"` // When the hosts were last pushed, for dynamic pools
+	Refresh   *PoolRefresh                 `json:",omitempty"` // For pools with sources
+}
+
+type PoolsByName []PoolReport
+
+func (slice PoolsByName) Len() int           { return len(slice) }
+func (slice PoolsByName) Less(i, j int) bool { return slice[i].Name < slice[j].Name }
+func (slice PoolsByName) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+
+// Ask for a pool's membership. A *PoolReport, or a string if there is no such
+// pool, is sent back. Without a name, every pool is reported as a *[]PoolReport
+type PoolStatusRequest struct {
+	Name string
+	Chan chan StatusResponse
+}
+
+func (pool *DynamicPool) save() (err error) {
+	path := filepath.Join(config.PoolCacheDir(), pool.Name+".json")
+	os.MkdirAll(config.PoolCacheDir(), 0755)
+	var b []byte
+	if b, err = json.Marshal(pool); err == nil {
+		err = ioutil.WriteFile(path, b, 0644)
+	}
+	return err
+}
+
+func loadDynamicPools() map[string]*DynamicPool {
+	pools := make(map[string]*DynamicPool)
+	files, _ := filepath.Glob(filepath.Join(config.PoolCacheDir(), "*.json"))
+	for _, fn := range files {
+		var pool DynamicPool
+		data, err := ioutil.ReadFile(fn)
+		if err == nil {
+			err = json.Unmarshal(data, &pool)
+		}
+		if err != nil {
+			log.Printf("Unable to restore pool from %s - %s\n", fn, err.Error())
+			continue
+		}
+		pools[pool.Name] = &pool
+	}
+	return pools
+}
+
+// Set a dynamic pool's hosts, saving them and updating the jobs that use it or
+// a pool built from it
+func updateDynamicPool(name string, hosts []string, cfg *config.Config, dynamic_pools map[string]*DynamicPool, jobs JobList) {
+	pool := &DynamicPool{Name: name, Hosts: hosts, UpdatedAt: time.Now()}
+	dynamic_pools[name] = pool
+	if err := pool.save(); err != nil {
+		log.Printf("Unable to save pool %s: %s", name, err.Error())
+	}
+	if cfg == nil {
+		return
+	}
+	changed := make(map[string]bool)
+	for _, pool_name := range cfg.SetPoolHosts(name, hosts) {
+		changed[pool_name] = true
+	}
+	for _, job := range jobs {
+		if job.PoolInst != nil && changed[job.PoolInst.Name] {
+			log.Printf("Updating job %s with updated pool %s", job.Name, job.PoolInst.Name)
+			spec := cfg.Pool[job.PoolInst.Name]
+			job.PoolInst.Host, job.PoolInst.Tags = spec.Host, spec.Tags
+			job.PoolIndex = 0
+		}
+	}
+}
+
+func reportPool(name string, cfg *config.Config, dynamic_pools map[string]*DynamicPool, refresher PoolRefresher) StatusResponse {
+	if name == "" {
+		pools := []PoolReport{}
+		if cfg != nil {
+			for pool_name := range cfg.Pool {
+				pools = append(pools, *reportPool(pool_name, cfg, dynamic_pools, refresher).(*PoolReport))
+			}
+		}
+		sort.Sort(PoolsByName(pools))
+		return &pools
+	}
+	var spec *config.PoolSpec
+	if cfg != nil {
+		spec = cfg.Pool[name]
+	}
+	dynamic := dynamic_pools[name]
+	if spec == nil && dynamic == nil {
+		return "Pool not found"
+	}
+	report := PoolReport{Name: name, Hosts: []string{}}
+	if spec != nil {
+		report.Dynamic = spec.Dynamic
+		report.Hosts = append(report.Hosts, spec.Host...)
+		report.Tags = spec.Tags
+	}
+	if dynamic != nil && (spec == nil || spec.Dynamic) {
+		report.Dynamic = true
+		report.Hosts, report.Tags = config.ParsePoolHosts(dynamic.Hosts)
+		report.UpdatedAt = &dynamic.UpdatedAt
+	}
+	if refresh := refresher[name]; refresh != nil {
+		status := *refresh
+		report.Refresh = &status
+	}
+	return &report
+}
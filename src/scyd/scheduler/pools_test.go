@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"scyd/config"
+	"testing"
+	"time"
+)
+
+func TestDynamicPools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scylla-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SCYLLA_PATH", dir)
+	defer os.Unsetenv("SCYLLA_PATH")
+
+	pool := DynamicPool{Name: "app-servers", Hosts: []string{"app-1", "app-2"}, UpdatedAt: time.Now()}
+	if err := pool.save(); err != nil {
+		t.Fatal(err)
+	}
+	pools := loadDynamicPools()
+	if restored := pools["app-servers"]; restored == nil || len(restored.Hosts) != 2 || !restored.UpdatedAt.Equal(pool.UpdatedAt) {
+		t.Fatalf("Expected the saved pool back, got %+v", restored)
+	}
+
+	cfg := &config.Config{Pool: map[string]*config.PoolSpec{
+		"app-servers": {Name: "app-servers", Dynamic: true},
+		"web":         {Name: "web", Host: []string{"web-1"}},
+	}}
+	if report, ok := reportPool("app-servers", cfg, pools, PoolRefresher{}).(*PoolReport); !ok || len(report.Hosts) != 2 || report.UpdatedAt == nil {
+		t.Errorf("Expected the pushed hosts for a dynamic pool, got %+v", report)
+	}
+	if report, ok := reportPool("web", cfg, pools, PoolRefresher{}).(*PoolReport); !ok || len(report.Hosts) != 1 || report.UpdatedAt != nil {
+		t.Errorf("Expected the config hosts for a static pool, got %+v", report)
+	}
+	if _, ok := reportPool("nowhere", cfg, pools, PoolRefresher{}).(string); !ok {
+		t.Error("Expected an unknown pool to be reported missing")
+	}
+
+	cfg.Pool["web-east"] = &config.PoolSpec{Name: "web-east", Select: "region=east"}
+	jobs := JobList{"deploy": &Job{JobSpec: config.JobSpec{Name: "deploy", PoolInst: cfg.Pool["web-east"]}, PoolIndex: 3}}
+	updateDynamicPool("app-servers", []string{"app-1 region=east", "app-2 region=west"}, cfg, pools, jobs)
+	if hosts := jobs["deploy"].PoolInst.Host; len(hosts) != 1 || hosts[0] != "app-1" || jobs["deploy"].PoolIndex != 0 {
+		t.Errorf("Expected jobs on a pool selecting pushed hosts to be updated, got %v", hosts)
+	}
+	if report := reportPool("app-servers", cfg, pools, PoolRefresher{}).(*PoolReport); report.Hosts[1] != "app-2" || report.Tags["app-2"]["region"] != "west" {
+		t.Errorf("Expected pushed hosts to be reported with their tags, got %+v", report)
+	}
+}
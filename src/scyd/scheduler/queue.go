@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"scyd/config"
+	"scyd/ssh"
+)
+
+// A host run waiting for (or holding) a concurrency slot
+type queuedRun struct {
+	hr    HostRun
+	pool  string
+	host  string
+	start func(HostRun)
+}
+
+// Limits the number of host runs in flight, globally, per pool and per host.
+// Runs beyond the limits wait in FIFO order. Owned by the scheduler goroutine
+type RunQueue struct {
+	ReportChan  chan HostRun
+	Clients     *ssh.ClientPool // Shared ssh connections. Nil to connect afresh for every run
+	maxRuns     int
+	poolLimits  map[string]int
+	hostLimits  map[string]int
+	active      map[string]*queuedRun
+	activePools map[string]int
+	activeHosts map[string]int
+	pending     []*queuedRun
+}
+
+func NewRunQueue(report_chan chan HostRun) *RunQueue {
+	return &RunQueue{
+		ReportChan:  report_chan,
+		poolLimits:  make(map[string]int),
+		hostLimits:  make(map[string]int),
+		active:      make(map[string]*queuedRun),
+		activePools: make(map[string]int),
+		activeHosts: make(map[string]int),
+	}
+}
+
+// Set limits from config. Runs already in flight are unaffected
+func (q *RunQueue) configure(cfg *config.Config) {
+	q.maxRuns = cfg.General.MaxConcurrentRuns
+	q.poolLimits = make(map[string]int)
+	for name, pool := range cfg.Pool {
+		if pool.MaxConcurrent > 0 {
+			q.poolLimits[name] = pool.MaxConcurrent
+		}
+	}
+	q.hostLimits = make(map[string]int)
+	for name, host := range cfg.Host {
+		if host.MaxConcurrent > 0 {
+			q.hostLimits[name] = host.MaxConcurrent
+		}
+	}
+}
+
+func runKey(hr *HostRun) string {
+	return fmt.Sprintf("%s.%d.%d", hr.JobName, hr.RunId, hr.HostId)
+}
+
+// Queue a host run. start is called (from the scheduler goroutine) once a slot is free
+func (q *RunQueue) submit(hr HostRun, pool string, start func(HostRun)) {
+	q.pending = append(q.pending, &queuedRun{hr: hr, pool: pool, host: config.HostName(hr.Host), start: start})
+}
+
+func (q *RunQueue) canStart(r *queuedRun) bool {
+	if q.maxRuns > 0 && len(q.active) >= q.maxRuns {
+		return false
+	}
+	if limit := q.poolLimits[r.pool]; r.pool != "" && limit > 0 && q.activePools[r.pool] >= limit {
+		return false
+	}
+	if limit := q.hostLimits[r.host]; limit > 0 && q.activeHosts[r.host] >= limit {
+		return false
+	}
+	return true
+}
+
+// Start as many pending runs as the limits allow, then renumber what is left
+func (q *RunQueue) dispatch(jobs JobList) {
+	remaining := q.pending[:0]
+	for _, r := range q.pending {
+		if !q.canStart(r) {
+			remaining = append(remaining, r)
+			continue
+		}
+		q.active[runKey(&r.hr)] = r
+		q.activePools[r.pool] += 1
+		q.activeHosts[r.host] += 1
+		if job := jobs[r.hr.JobName]; job != nil {
+			job.markHostRun(r.hr.RunId, r.hr.HostId, Running, 0)
+		}
+		r.hr.Status = Running
+		r.start(r.hr)
+	}
+	q.pending = remaining
+	for i, r := range q.pending {
+		if job := jobs[r.hr.JobName]; job != nil {
+			job.markHostRun(r.hr.RunId, r.hr.HostId, Queued, i+1)
+		}
+	}
+}
+
+// Free the slot held by a completed host run
+func (q *RunQueue) release(hr *HostRun) {
+	key := runKey(hr)
+	r := q.active[key]
+	if r == nil {
+		return
+	}
+	delete(q.active, key)
+	q.activePools[r.pool] -= 1
+	q.activeHosts[r.host] -= 1
+}
+
+// Drop queued runs for a job (eg, when it is removed on reload)
+func (q *RunQueue) drop(jobname string) {
+	remaining := q.pending[:0]
+	for _, r := range q.pending {
+		if r.hr.JobName != jobname {
+			remaining = append(remaining, r)
+		} else {
+			log.Printf("Dropping queued run %s.%d on %s", jobname, r.hr.RunId, r.hr.Host)
+		}
+	}
+	q.pending = remaining
+}
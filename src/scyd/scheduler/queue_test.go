@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"scyd/config"
+	"testing"
+)
+
+func TestRunQueueLimits(t *testing.T) {
+	queue := NewRunQueue(nil)
+	cfg := config.Config{
+		General: config.General{MaxConcurrentRuns: 3},
+		Pool:    map[string]*config.PoolSpec{"web": {Name: "web", MaxConcurrent: 2}},
+		Host:    map[string]*config.HostSpec{"db": {Name: "db", MaxConcurrent: 1}},
+	}
+	queue.configure(&cfg)
+	started := 0
+	start := func(hr HostRun) { started += 1 }
+	for i := 0; i < 3; i++ {
+		queue.submit(HostRun{JobName: "web-job", HostId: i, Host: "scylla@web-1"}, "web", start)
+	}
+	queue.submit(HostRun{JobName: "db-job", HostId: 0, Host: "scylla@db:22"}, "", start)
+	queue.submit(HostRun{JobName: "db-job2", HostId: 0, Host: "db"}, "", start)
+	queue.dispatch(JobList{})
+	if started != 3 {
+		t.Errorf("Expected 3 started runs, got %d", started)
+	}
+	if len(queue.pending) != 2 {
+		t.Fatalf("Expected 2 queued runs, got %d", len(queue.pending))
+	}
+	if queue.pending[0].hr.JobName != "web-job" || queue.pending[1].hr.JobName != "db-job2" {
+		t.Errorf("Unexpected queue order: %s, %s", queue.pending[0].hr.JobName, queue.pending[1].hr.JobName)
+	}
+	// Finishing the db run frees a global slot, but not a pool slot
+	queue.release(&HostRun{JobName: "db-job", HostId: 0})
+	queue.dispatch(JobList{})
+	if started != 4 || len(queue.pending) != 1 || queue.pending[0].hr.JobName != "web-job" {
+		t.Errorf("Expected db-job2 to start, got %d started, %d pending", started, len(queue.pending))
+	}
+}
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -20,13 +21,22 @@ type CommandRun struct {
 	StdErrURI        string `json:",omitempty"`
 }
 
+// A failed attempt to connect to a host
+type ConnectAttempt struct {
+	Time  time.Time
+	Error string
+}
+
 type HostRun struct {
 	RunInfo
-	JobName     string
-	RunId       int
-	Host        string
-	HostId      int
-	CommandRuns []CommandRun
+	JobName       string
+	RunId         int
+	Host          string
+	HostId        int
+	QueuePosition int `json:",omitempty"`
+	CommandRuns   []CommandRun
+	// Failed connection attempts, in order
+	ConnectAttempts []ConnectAttempt `json:",omitempty"`
 }
 
 type JobRun struct {
@@ -34,6 +44,7 @@ type JobRun struct {
 	RunId     int
 	JobName   string `json:",omitempty"`
 	HostRuns  []HostRun
+	Warning   string `json:",omitempty"`
 	DetailURI string `json:",omitempty"`
 }
 
@@ -61,23 +72,32 @@ func (jr JobRun) GetHostRunById(id int) *HostRun {
 	return nil
 }
 
-func (jr *JobRun) updateStatus() {
+// Update run status once every host run has completed. The run succeeds if at
+// least required host runs succeeded, with a warning if any host failed
+func (jr *JobRun) updateStatus(required int) {
 	if jr.Status != Running {
 		return
 	}
 	completed := 0
+	succeeded := 0
 	for _, hr := range jr.HostRuns {
-		if hr.Status == Failed {
-			jr.Status = Failed
-			jr.EndTime = time.Now()
-			return
-		}
-		if hr.Status != Running {
+		if hr.Status != Running && hr.Status != Queued {
 			completed += 1
 		}
+		if hr.Status == Succeeded {
+			succeeded += 1
+		}
 	}
-	if completed == len(jr.HostRuns) {
+	if completed < len(jr.HostRuns) {
+		return
+	}
+	jr.EndTime = time.Now()
+	if succeeded >= required {
 		jr.Status = Succeeded
-		jr.EndTime = time.Now()
+		if failed := completed - succeeded; failed > 0 {
+			jr.Warning = fmt.Sprintf("%d of %d hosts failed", failed, len(jr.HostRuns))
+		}
+	} else {
+		jr.Status = Failed
 	}
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"scyd/config"
+	"scyd/ssh"
 	"sort"
 	"time"
 )
@@ -26,8 +27,23 @@ type StatusRequest struct {
 	Chan   chan StatusResponse
 }
 
-// Load config request
-type LoadConfigRequest string
+// Load config request. If Chan is set, a *ReloadResult is sent back once the
+// config has been applied (or, with DryRun, just compared)
+type LoadConfigRequest struct {
+	Path   string
+	DryRun bool
+	Source string // What asked for the reload, for the log
+	Chan   chan StatusResponse
+}
+
+type ReloadResult struct {
+	Applied  bool
+	Problems []config.Problem   `json:",omitempty"`
+	Diff     *config.ConfigDiff `json:",omitempty"`
+	// Problems with jobs created through the API, which are left out rather
+	// than stopping the reload
+	ApiJobProblems []config.Problem `json:",omitempty"`
+}
 
 // Run a job
 type RunJobRequest string
@@ -38,6 +54,16 @@ type ChangeJobStatusRequest struct {
 	Status RunStatus
 }
 
+// Report connection pool statistics
+type ConnectionStatusRequest struct {
+	Chan chan StatusResponse
+}
+
+// Report lock holders and waiters
+type LockStatusRequest struct {
+	Chan chan StatusResponse
+}
+
 type UpdatePoolRequest struct {
 	Name  string
 	Hosts []string
@@ -45,7 +71,7 @@ type UpdatePoolRequest struct {
 
 func Run() (request_chan chan Request) {
 	request_chan = make(chan Request)
-	go runSchedule(request_chan)
+	go runSchedule(request_chan, ssh.NewClientPool())
 	return request_chan
 }
 
@@ -53,7 +79,7 @@ func saveConfig(cfg *config.Config) (err error) {
 	path := filepath.Join(config.RunDir(), "config.json")
 	os.MkdirAll(config.RunDir(), 0755)
 	var b []byte
-	if b, err = json.Marshal(cfg); err == nil {
+	if b, err = config.RedactedJson(cfg); err == nil {
 		err = ioutil.WriteFile(path, b, 0644)
 	}
 	return err
@@ -133,15 +159,41 @@ func reportJobRun(jobs *JobList, name string, runid string, rchan chan StatusRes
 	rchan <- jr
 }
 
-func runSchedule(request_chan chan Request) {
-	dynamic_pools := make(map[string][]string)
+func configurePool(clients *ssh.ClientPool, cfg *config.Config) {
+	c := cfg.Connections
+	clients.Configure(c.Pool, c.MaxSessions, c.IdleTimeout, c.KeepaliveInterval)
+}
+
+// Retry jobs waiting on locks, in the order they started waiting
+func runLockWaiters(jobs JobList, queue *RunQueue, locks *LockManager) {
+	for _, name := range locks.waiting() {
+		job := jobs[name]
+		if job == nil {
+			locks.cancel(name)
+			continue
+		}
+		job.run(queue, locks)
+		job.save()
+	}
+}
+
+func runSchedule(request_chan chan Request, clients *ssh.ClientPool) {
+	dynamic_pools := loadDynamicPools()
+	refresher := PoolRefresher{}
 	notifiers := make(map[string]*JobNotifier)
 	var cur_config *config.Config
+	var config_status ConfigStatus
 	var err error
 	if cur_config, err = loadConfig(); err != nil {
 		log.Printf("Unable to load last known good config: %s", err.Error())
 	} else {
 		log.Printf("Reloaded current good config. %d jobs and %d host pools", len(cur_config.Job), len(cur_config.Pool))
+		for name, pool := range cur_config.Pool {
+			if pool.Dynamic && dynamic_pools[name] != nil {
+				log.Printf("Restored %d hosts for dynamic pool %s", len(dynamic_pools[name].Hosts), name)
+				cur_config.SetPoolHosts(name, dynamic_pools[name].Hosts)
+			}
+		}
 	}
 	jobs := JobList{}
 	log.Printf("Loading saved job state...")
@@ -153,35 +205,45 @@ func runSchedule(request_chan chan Request) {
 	log.Printf("Done..")
 
 	run_report_chan := make(chan HostRun)
+	queue := NewRunQueue(run_report_chan)
+	queue.Clients = clients
+	locks := NewLockManager()
+	if cur_config != nil {
+		queue.configure(cur_config)
+		locks.configure(cur_config)
+		configurePool(clients, cur_config)
+		refresher.configure(cur_config)
+	}
 
 	// Run any run-on-start jobs
 	for _, job := range jobs {
 		if job.RunOnStart {
-			job.run(run_report_chan)
+			job.run(queue, locks)
 		}
 	}
+	queue.dispatch(jobs)
 
 	for {
 		select {
 		case <-time.After(time.Second * TIMEOUT): // Check for job runs
 			for _, job := range jobs {
 				if job.isTimeForJob() {
-					job.run(run_report_chan)
+					job.run(queue, locks)
 					job.save()
 				}
 			}
+			queue.dispatch(jobs)
+			refresher.start(request_chan)
 		case base_req := <-request_chan: // Client requests/commands
 			switch req := base_req.(type) {
 			case UpdatePoolRequest:
 				log.Printf("Received update for pool: %s", req.Name)
-				hosts := req.Hosts
-				dynamic_pools[req.Name] = hosts
-				for _, job := range jobs {
-					if job.PoolInst != nil && job.PoolInst.Name == req.Name && job.PoolInst.Dynamic {
-						log.Printf("Updating job %s with updated pool %s", job.Name, req.Name)
-						job.PoolInst.Host = hosts
-						job.PoolIndex = 0
-					}
+				updateDynamicPool(req.Name, req.Hosts, cur_config, dynamic_pools, jobs)
+			case PoolRefreshedRequest:
+				if refresher.finished(req) {
+					updateDynamicPool(req.Name, req.Hosts, cur_config, dynamic_pools, jobs)
+				} else if req.Error != "" {
+					log.Printf("Unable to refresh pool %s: %s", req.Name, req.Error)
 				}
 
 			case ChangeJobStatusRequest:
@@ -191,28 +253,48 @@ func runSchedule(request_chan chan Request) {
 					log.Printf("Changing status of job %s to %d", req.Name, req.Status)
 					job.Status = req.Status
 					job.save()
+					if job.Status != Running {
+						locks.release(job.Name)
+						runLockWaiters(jobs, queue, locks)
+						queue.dispatch(jobs)
+					}
 				}
 			case RunJobRequest:
 				name := string(req)
 				log.Printf("Manual job run request for: %s", name)
 				job := jobs[name]
 				if job != nil {
-					job.run(run_report_chan)
+					job.run(queue, locks)
+					queue.dispatch(jobs)
 					job.save()
 				}
 			case LoadConfigRequest:
-				log.Println("Got config load request.")
-				path := string(req)
-				cfg, err := config.New(path)
+				log.Printf("Got config load request (%s).", req.Source)
+				result := ReloadResult{}
+				cfg, err := config.New(req.Path)
 				if err != nil {
-					log.Printf("Unable to parse %s : %s\n", path, err.Error)
-				} else {
+					log.Printf("Unable to parse %s : %s\n", req.Path, err.Error())
+					result.Problems = config.Problems(err)
+				}
+				if !req.DryRun {
+					config_status.loaded(req.Path, cfg, result.Problems)
+				}
+				if err == nil {
+					result.ApiJobProblems = cfg.AddApiJobs()
+					for _, p := range result.ApiJobProblems {
+						log.Printf("Leaving out API job: %s", p.String())
+					}
 					// If the config has dymamic pools, update them from any current dynamic pools
 					for name, pool := range cfg.Pool {
 						if pool.Dynamic && dynamic_pools[name] != nil {
-							cfg.Pool[name].Host = dynamic_pools[name]
+							cfg.SetPoolHosts(name, dynamic_pools[name].Hosts)
 						}
 					}
+					diff := config.Diff(cur_config, cfg)
+					result.Diff = &diff
+				}
+				if err == nil && !req.DryRun {
+					result.Applied = true
 					new_jobs := JobList{}
 					for name, job := range cfg.Job {
 						if jobs[name] == nil {
@@ -234,8 +316,7 @@ func runSchedule(request_chan chan Request) {
 					// Delete old job state files
 					for name, _ := range jobs {
 						if new_jobs[name] == nil {
-							log.Printf("Removing old job file for %s\n", name)
-							os.Remove(filepath.Join(config.JobDir(), name+".json"))
+							removeJob(name, jobs, queue, locks)
 						}
 					}
 					notifiers = make(map[string]*JobNotifier)
@@ -246,7 +327,36 @@ func runSchedule(request_chan chan Request) {
 					jobs = new_jobs
 					saveConfig(cfg)
 					cur_config = cfg
+					queue.configure(cfg)
+					locks.configure(cfg)
+					configurePool(clients, cfg)
+					refresher.configure(cfg)
+					refresher.start(request_chan)
+					runLockWaiters(jobs, queue, locks)
+					queue.dispatch(jobs)
+				}
+				if req.Chan != nil {
+					req.Chan <- &result
 				}
+			case ChangeJobRequest:
+				log.Printf("Got %s request for job %s", req.Method, req.Name)
+				result := changeApiJob(req, cur_config, jobs, queue, locks)
+				if result.Applied {
+					runLockWaiters(jobs, queue, locks)
+					queue.dispatch(jobs)
+				}
+				req.Chan <- result
+			case PoolStatusRequest:
+				req.Chan <- reportPool(req.Name, cur_config, dynamic_pools, refresher)
+			case ConfigStatusRequest:
+				status := config_status.report()
+				req.Chan <- &status
+			case ConnectionStatusRequest:
+				stats := clients.Stats()
+				req.Chan <- &stats
+			case LockStatusRequest:
+				data := locks.report()
+				req.Chan <- &data
 			case StatusRequest:
 				switch len(req.Object) {
 				case 0:
@@ -259,12 +369,20 @@ func runSchedule(request_chan chan Request) {
 
 			}
 		case run_report := <-run_report_chan: // Job status from goproc command runners
+			if run_report.Status != Running {
+				queue.release(&run_report)
+				queue.dispatch(jobs)
+			}
 			job := jobs[run_report.JobName]
 			if job == nil {
 				log.Printf("Received run report for unknown job: %s. Discarding\n", run_report.JobName)
 				break
 			}
-			job.complete(&run_report, notifiers[job.Notifier])
+			if job.complete(&run_report, notifiers[job.Notifier]) {
+				locks.release(job.Name)
+				runLockWaiters(jobs, queue, locks)
+				queue.dispatch(jobs)
+			}
 		}
 	}
 }
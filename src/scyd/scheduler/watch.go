@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"scyd/config"
+	"time"
+)
+
+const CONFIG_WATCH_INTERVAL = 2 * time.Second
+
+// Files must be unchanged this long before a reload, so a half-written save isn't picked up
+const CONFIG_WATCH_DEBOUNCE = 3 * time.Second
+
+// State of the running config relative to the files on disk
+type ConfigStatus struct {
+	Path       string
+	Files      []string // Config file plus anything it includes
+	Watch      bool     // Reload automatically when the files change
+	LoadedAt   time.Time
+	RejectedAt time.Time        `json:",omitempty"`
+	Problems   []config.Problem `json:",omitempty"` // Why the last reload was rejected
+	Differs    bool             // Running config is not what's on disk
+}
+
+// Ask for the config status
+type ConfigStatusRequest struct {
+	Chan chan StatusResponse
+}
+
+// Record the result of a reload attempt
+func (cs *ConfigStatus) loaded(path string, cfg *config.Config, problems []config.Problem) {
+	if cs.Path != path || len(cs.Files) == 0 {
+		cs.Files = []string{path}
+	}
+	cs.Path = path
+	if len(problems) > 0 {
+		cs.RejectedAt = time.Now()
+		cs.Problems = problems
+		return
+	}
+	cs.LoadedAt = time.Now()
+	cs.RejectedAt = time.Time{}
+	cs.Problems = nil
+	if files := cfg.Files(); len(files) > 0 {
+		cs.Files = files
+	}
+	cs.Watch = cfg.General.WatchConfig
+}
+
+// Copy of the status with Differs filled in from the files' modification times
+func (cs *ConfigStatus) report() ConfigStatus {
+	status := *cs
+	status.Differs = len(cs.Problems) > 0
+	for _, fn := range cs.Files {
+		if stat, err := os.Stat(fn); err != nil || stat.ModTime().After(cs.LoadedAt) {
+			status.Differs = true
+		}
+	}
+	return status
+}
+
+// Modification times and sizes of files, to spot changes
+func fileSignature(files []string) string {
+	sig := ""
+	for _, fn := range files {
+		if stat, err := os.Stat(fn); err == nil {
+			sig += fmt.Sprintf("%s %d %d;", fn, stat.ModTime().UnixNano(), stat.Size())
+		} else {
+			sig += fn + " missing;"
+		}
+	}
+	return sig
+}
+
+// Poll the config files, asking for a reload once they have changed and then
+// been left alone for CONFIG_WATCH_DEBOUNCE. Does nothing unless watch-config is
+// set. Runs forever
+func WatchConfig(request_chan chan Request) {
+	var applied, last string
+	var changed time.Time
+	for {
+		time.Sleep(CONFIG_WATCH_INTERVAL)
+		resp_chan := make(chan StatusResponse)
+		request_chan <- ConfigStatusRequest{Chan: resp_chan}
+		status := (<-resp_chan).(*ConfigStatus)
+		sig := fileSignature(status.Files)
+		if !status.Watch || applied == "" {
+			applied, last = sig, sig
+			continue
+		}
+		if sig != last {
+			last, changed = sig, time.Now()
+			continue
+		}
+		if sig != applied && time.Since(changed) >= CONFIG_WATCH_DEBOUNCE {
+			log.Printf("Config files changed. Reloading %s", status.Path)
+			applied = sig // Rejected or not, wait for the next change before trying again
+			request_chan <- LoadConfigRequest{Path: status.Path, Source: "file change"}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"scyd/config"
+	"testing"
+	"time"
+)
+
+func TestConfigStatusDiffers(t *testing.T) {
+	f, err := ioutil.TempFile("", "scylla-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(f.Name(), old, old)
+
+	var status ConfigStatus
+	status.loaded(f.Name(), &config.Config{General: config.General{WatchConfig: true}}, nil)
+	if r := status.report(); r.Differs || !r.Watch {
+		t.Errorf("Freshly loaded config should match and be watched: %+v", r)
+	}
+	sig := fileSignature(status.Files)
+	os.Chtimes(f.Name(), time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if !status.report().Differs {
+		t.Error("Config should differ after the file changed")
+	}
+	if fileSignature(status.Files) == sig {
+		t.Error("File signature should change with the modification time")
+	}
+
+	status.loaded(f.Name(), nil, []config.Problem{{Message: "bad"}})
+	if r := status.report(); !r.Differs || len(r.Problems) != 1 || r.RejectedAt.IsZero() || !r.Watch {
+		t.Errorf("Rejected reload should be reported and keep watching: %+v", r)
+	}
+}
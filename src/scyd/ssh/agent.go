@@ -0,0 +1,27 @@
+package ssh
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+)
+
+// Connect to the ssh-agent named by SSH_AUTH_SOCK
+func dialAgent() (net.Conn, agent.Agent, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("ssh-agent requested, but SSH_AUTH_SOCK is not set")
+	}
+	agent_conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, errors.New("Unable to connect to ssh-agent: " + err.Error())
+	}
+	return agent_conn, agent.NewClient(agent_conn), nil
+}
+
+// Auth method that signs with every key held by the agent
+func agentAuth(keyring agent.Agent) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(keyring.Signers)
+}
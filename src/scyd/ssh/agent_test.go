@@ -0,0 +1,74 @@
+package ssh
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"testing"
+)
+
+func TestAgentAuth(t *testing.T) {
+	key, signer := mkTestKey(t)
+	defer startTestAgent(t, key)()
+	server := startTestServer(t, signer.PublicKey())
+	defer server.Close()
+	server.exec = func(cmd string, ch ssh.Channel, conn *ssh.ServerConn, killed chan bool) uint32 {
+		fmt.Fprint(ch, "ran "+cmd)
+		return 0
+	}
+
+	conn := SshConnection{}
+	if err := conn.Open("scylla@"+server.addr, nil, 5); err == nil {
+		t.Error("Expected to fail without the agent or any keys")
+		conn.Close()
+	}
+	conn = SshConnection{UseAgent: true}
+	if err := conn.Open("scylla@"+server.addr, nil, 5); err != nil {
+		t.Fatal("Unable to connect with the agent's key: " + err.Error())
+	}
+	defer conn.Close()
+	stdout, _, err := conn.Run("uptime", 5, false)
+	if err != nil || *stdout != "ran uptime" {
+		t.Errorf("Unexpected output %q (%v)", *stdout, err)
+	}
+	if server.agentRequests() != 0 {
+		t.Error("Agent should not be forwarded unless asked for")
+	}
+}
+
+func TestAgentForwarding(t *testing.T) {
+	key, signer := mkTestKey(t)
+	defer startTestAgent(t, key)()
+	server := startTestServer(t, signer.PublicKey())
+	defer server.Close()
+	// List the keys in the forwarded agent, as git on the remote host would
+	server.exec = func(cmd string, ch ssh.Channel, conn *ssh.ServerConn, killed chan bool) uint32 {
+		agent_chan, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+		if err != nil {
+			fmt.Fprint(ch, err.Error())
+			return 1
+		}
+		defer agent_chan.Close()
+		go ssh.DiscardRequests(reqs)
+		keys, err := agent.NewClient(agent_chan).List()
+		if err != nil {
+			fmt.Fprint(ch, err.Error())
+			return 1
+		}
+		fmt.Fprintf(ch, "%d key(s)", len(keys))
+		return 0
+	}
+
+	conn := SshConnection{UseAgent: true, AgentForwarding: true}
+	if err := conn.Open("scylla@"+server.addr, nil, 5); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stdout, _, err := conn.Run("git pull", 5, false)
+	if err != nil || *stdout != "1 key(s)" {
+		t.Errorf("Expected the forwarded agent's key, got %q (%v)", *stdout, err)
+	}
+	if server.agentRequests() != 1 {
+		t.Errorf("Expected one agent forwarding request, got %d", server.agentRequests())
+	}
+}
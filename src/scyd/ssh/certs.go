@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Read an OpenSSH certificate (the contents of a -cert.pub file)
+func loadCert(cert_filename string) (*ssh.Certificate, error) {
+	data, err := ioutil.ReadFile(cert_filename)
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to parse %s (%s)", cert_filename, err.Error()))
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New(cert_filename + " is not a certificate")
+	}
+	return cert, nil
+}
+
+// Wrap a signer with the certificate stored next to its key file, if there is one
+func withAdjacentCert(key_filename string, signer ssh.Signer) (ssh.Signer, error) {
+	cert, err := loadCert(key_filename + "-cert.pub")
+	if os.IsNotExist(err) {
+		return signer, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return certSigner(cert, signer, key_filename+"-cert.pub")
+}
+
+func certSigner(cert *ssh.Certificate, signer ssh.Signer, cert_filename string) (ssh.Signer, error) {
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, errors.New(cert_filename + " does not match its private key")
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// Wrap signers with explicitly configured certificates, matching each
+// certificate to the private key it was issued for
+func attachCerts(signers []ssh.Signer, keys []ssh.PublicKey, cert_filenames []string) error {
+	for _, cert_filename := range cert_filenames {
+		cert, err := loadCert(cert_filename)
+		if err != nil {
+			return err
+		}
+		matched := false
+		for i, key := range keys {
+			if bytes.Equal(cert.Key.Marshal(), key.Marshal()) {
+				if signers[i], err = ssh.NewCertSigner(cert, signers[i]); err != nil {
+					return err
+				}
+				matched = true
+			}
+		}
+		if !matched {
+			return errors.New(cert_filename + " does not match any key file")
+		}
+	}
+	return nil
+}
+
+// Report certificates that are expired or not yet valid
+func CheckCertificates(signers []ssh.Signer, now time.Time) error {
+	unix := uint64(now.Unix())
+	for _, signer := range signers {
+		cert, ok := signer.PublicKey().(*ssh.Certificate)
+		if !ok {
+			continue
+		}
+		if unix < cert.ValidAfter {
+			return errors.New(fmt.Sprintf("Certificate %s is not valid until %s", cert.KeyId, time.Unix(int64(cert.ValidAfter), 0).Format(time.RFC822)))
+		}
+		if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+			return errors.New(fmt.Sprintf("Certificate %s expired %s", cert.KeyId, time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC822)))
+		}
+	}
+	return nil
+}
+
+// Accept host certificates signed by any CA key in ca_filename (authorized_keys
+// format). Hosts presenting plain keys are checked by fallback
+func MakeHostCACallback(ca_filename string, fallback ssh.HostKeyCallback) (ssh.HostKeyCallback, error) {
+	data, err := ioutil.ReadFile(ca_filename)
+	if err != nil {
+		return nil, errors.New("Unable to read host CA file: " + err.Error())
+	}
+	var authorities [][]byte
+	for len(bytes.TrimSpace(data)) > 0 {
+		var key ssh.PublicKey
+		key, _, _, data, err = ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse host CA file %s (%s)", ca_filename, err.Error()))
+		}
+		authorities = append(authorities, key.Marshal())
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, authority := range authorities {
+				if bytes.Equal(authority, auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: fallback,
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := checker.CheckHostKey(hostname, remote, key)
+		if _, is_cert := key.(*ssh.Certificate); is_cert && err != nil {
+			host := hostname
+			if h, _, split_err := net.SplitHostPort(hostname); split_err == nil {
+				host = h
+			}
+			return &HostKeyError{host, strings.TrimPrefix(err.Error(), "ssh: ")}
+		}
+		return err
+	}, nil
+}
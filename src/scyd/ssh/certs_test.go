@@ -0,0 +1,60 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a private key and a certificate for it, valid from after until before
+func mkCertKey(t *testing.T, dir string, after time.Time, before time.Time) string {
+	priv, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ca_priv, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ca, _ := ssh.NewSignerFromKey(ca_priv)
+	pub, _ := ssh.NewPublicKey(&priv.PublicKey)
+	cert := &ssh.Certificate{
+		Key:         pub,
+		KeyId:       "scylla-test",
+		CertType:    ssh.UserCert,
+		ValidAfter:  uint64(after.Unix()),
+		ValidBefore: uint64(before.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "id_rsa")
+	key_pem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	ioutil.WriteFile(path, key_pem, 0600)
+	ioutil.WriteFile(path+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644)
+	return path
+}
+
+func TestCertificateSigner(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scylla-certs")
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	path := mkCertKey(t, dir, now.Add(-time.Hour), now.Add(time.Hour))
+	signers, err := LoadSigners([]string{path}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := signers[0].PublicKey().(*ssh.Certificate); !ok {
+		t.Error("Expected a certificate signer")
+	}
+	if err := CheckCertificates(signers, now); err != nil {
+		t.Errorf("Certificate should be valid: %s", err.Error())
+	}
+	if err := CheckCertificates(signers, now.Add(2*time.Hour)); err == nil {
+		t.Error("Certificate should have expired")
+	}
+	if err := CheckCertificates(signers, now.Add(-2*time.Hour)); err == nil {
+		t.Error("Certificate should not be valid yet")
+	}
+}
@@ -0,0 +1,55 @@
+package ssh
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"time"
+)
+
+// A bastion host to tunnel through. Auths may be empty, in which case the
+// target host's auth methods are used for the hop as well
+type JumpHost struct {
+	Server string // user@host[:port]
+	Auths  []ssh.AuthMethod
+}
+
+type hopResult struct {
+	client       *ssh.Client
+	network_conn net.Conn
+	err          error
+}
+
+// Dial addr and complete the ssh handshake within timeout (0 for no timeout)
+func connectHop(dial func(string) (net.Conn, error), addr string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, net.Conn, error) {
+	done := make(chan hopResult, 1)
+	go func() {
+		network_conn, err := dial(addr)
+		if err != nil {
+			done <- hopResult{err: err}
+			return
+		}
+		client_conn, new_chan, req_chan, err := ssh.NewClientConn(network_conn, addr, config)
+		if err != nil {
+			network_conn.Close()
+			done <- hopResult{err: err}
+			return
+		}
+		done <- hopResult{client: ssh.NewClient(client_conn, new_chan, req_chan), network_conn: network_conn}
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case r := <-done:
+		return r.client, r.network_conn, r.err
+	case <-expired:
+		go func() { // Clean up if the handshake completes after all
+			if r := <-done; r.client != nil {
+				r.client.Close()
+			}
+		}()
+		return nil, nil, errors.New("Timed out connecting to " + addr)
+	}
+}
@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Host key policies
+const HOST_KEY_STRICT = "strict"
+const HOST_KEY_ACCEPT_NEW = "accept-new"
+const HOST_KEY_INSECURE = "insecure"
+
+// Serializes trust-on-first-use writes to the managed known_hosts file
+var known_hosts_lock sync.Mutex
+
+// Host key verification failure. Never worth retrying
+type HostKeyError struct {
+	Host   string
+	Reason string
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("Host key verification failed for %s: %s", e.Host, e.Reason)
+}
+
+func ValidHostKeyPolicy(policy string) bool {
+	return policy == "" || policy == HOST_KEY_STRICT || policy == HOST_KEY_ACCEPT_NEW || policy == HOST_KEY_INSECURE
+}
+
+// Build a host key callback for a policy. known_hosts is an optional user-supplied
+// file; managed is scylla's own known_hosts file, which accept-new appends to.
+// An empty policy is treated as insecure
+func MakeHostKeyCallback(policy string, known_hosts string, managed string) (ssh.HostKeyCallback, error) {
+	if policy == "" || policy == HOST_KEY_INSECURE {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if !ValidHostKeyPolicy(policy) {
+		return nil, errors.New("Unknown host key policy: " + policy)
+	}
+	if err := touch(managed); err != nil {
+		return nil, err
+	}
+	files := []string{managed}
+	if known_hosts != "" {
+		if policy == HOST_KEY_STRICT {
+			files = []string{known_hosts}
+		} else {
+			files = []string{known_hosts, managed}
+		}
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read known hosts: %s", err.Error()))
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		if ke, ok := err.(*knownhosts.KeyError); ok {
+			if len(ke.Want) == 0 {
+				if policy == HOST_KEY_ACCEPT_NEW {
+					return addKnownHost(managed, hostname, key)
+				}
+				return &HostKeyError{hostname, fmt.Sprintf("unknown host (%s key %s)", key.Type(), ssh.FingerprintSHA256(key))}
+			}
+			want := ke.Want[0]
+			return &HostKeyError{hostname, fmt.Sprintf("key mismatch. Server sent %s key %s, expected %s (%s:%d)",
+				key.Type(), ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(want.Key), want.Filename, want.Line)}
+		}
+		return &HostKeyError{hostname, err.Error()}
+	}, nil
+}
+
+func addKnownHost(path string, hostname string, key ssh.PublicKey) error {
+	known_hosts_lock.Lock()
+	defer known_hosts_lock.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return &HostKeyError{hostname, "unable to record new host key: " + err.Error()}
+	}
+	defer f.Close()
+	_, err = f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	return err
+}
+
+func touch(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mkHostKey(t *testing.T) ssh.PublicKey {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+var remote = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+func TestAcceptNewHostKey(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scylla-known-hosts")
+	defer os.RemoveAll(dir)
+	managed := filepath.Join(dir, "known_hosts")
+	key := mkHostKey(t)
+	cb, err := MakeHostKeyCallback(HOST_KEY_ACCEPT_NEW, "", managed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb("web-1:22", remote, key); err != nil {
+		t.Errorf("New host should be accepted: %s", err.Error())
+	}
+	// Reloaded callbacks should now know the host
+	cb, _ = MakeHostKeyCallback(HOST_KEY_STRICT, "", managed)
+	if err := cb("web-1:22", remote, key); err != nil {
+		t.Errorf("Known host should be accepted: %s", err.Error())
+	}
+	err = cb("web-1:22", remote, mkHostKey(t))
+	if _, ok := err.(*HostKeyError); !ok {
+		t.Errorf("Expected a host key mismatch, got %v", err)
+	}
+	if err := cb("web-2:22", remote, key); err == nil {
+		t.Error("Strict policy should reject unknown hosts")
+	}
+}
@@ -0,0 +1,262 @@
+package ssh
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const POOL_CHECK_INTERVAL = 5 * time.Second
+
+// Shares ssh clients between host runs. Clients are keyed by server plus
+// everything that affects authentication, so a key must only be reused for
+// identical connection settings
+type ClientPool struct {
+	lock         sync.Mutex
+	enabled      bool
+	max_sessions int
+	idle_timeout time.Duration
+	keepalive    time.Duration
+	clients      map[string][]*pooledClient
+	hits         int
+	misses       int
+	evictions    int
+}
+
+type pooledClient struct {
+	key          string
+	server       string
+	conn         *SshConnection
+	sessions     int
+	uses         int
+	last_used    time.Time
+	last_checked time.Time
+	broken       bool
+}
+
+// A client checked out of the pool. Close returns it to the pool
+type PooledConnection struct {
+	pool   *ClientPool
+	client *pooledClient
+	CommandOptions
+}
+
+type PooledClientStats struct {
+	Server      string
+	Sessions    int
+	Uses        int
+	IdleSeconds int
+	Broken      bool
+}
+
+type PoolStats struct {
+	Enabled     bool
+	MaxSessions int
+	IdleTimeout int
+	Keepalive   int
+	Hits        int
+	Misses      int
+	Evictions   int
+	Connections []PooledClientStats
+}
+
+type ClientsByServer []PooledClientStats
+
+func (slice ClientsByServer) Len() int           { return len(slice) }
+func (slice ClientsByServer) Less(i, j int) bool { return slice[i].Server < slice[j].Server }
+func (slice ClientsByServer) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+
+func NewClientPool() *ClientPool {
+	pool := &ClientPool{clients: make(map[string][]*pooledClient), max_sessions: 1}
+	go pool.maintain()
+	return pool
+}
+
+// Change pool settings. Disabling the pool closes idle clients; busy ones are
+// closed when they are returned
+func (pool *ClientPool) Configure(enabled bool, max_sessions int, idle_timeout int, keepalive int) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.enabled = enabled
+	pool.max_sessions = max_sessions
+	if pool.max_sessions < 1 {
+		pool.max_sessions = 1
+	}
+	pool.idle_timeout = time.Duration(idle_timeout) * time.Second
+	pool.keepalive = time.Duration(keepalive) * time.Second
+	if !enabled {
+		for _, clients := range pool.clients {
+			for _, pc := range clients {
+				pc.broken = true
+			}
+		}
+		pool.evict()
+	}
+}
+
+func (pool *ClientPool) Enabled() bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return pool.enabled
+}
+
+// Check out a client for server, calling open to connect if no pooled client
+// has a free session
+func (pool *ClientPool) Get(key string, server string, open func() (*SshConnection, error)) (*PooledConnection, error) {
+	pool.lock.Lock()
+	for _, pc := range pool.clients[key] {
+		if !pc.broken && pc.sessions < pool.max_sessions {
+			pc.sessions += 1
+			pool.hits += 1
+			pool.lock.Unlock()
+			return &PooledConnection{pool: pool, client: pc}, nil
+		}
+	}
+	pool.misses += 1
+	pool.lock.Unlock()
+
+	conn, err := open()
+	if err != nil {
+		return nil, err
+	}
+	conn.shared = true
+	now := time.Now()
+	pc := &pooledClient{key: key, server: server, conn: conn, sessions: 1, last_used: now, last_checked: now}
+	pool.lock.Lock()
+	pool.clients[key] = append(pool.clients[key], pc)
+	pool.lock.Unlock()
+	return &PooledConnection{pool: pool, client: pc}, nil
+}
+
+// Close and remove broken and idle clients. Call with the lock held
+func (pool *ClientPool) evict() {
+	now := time.Now()
+	for key, clients := range pool.clients {
+		kept := clients[:0]
+		for _, pc := range clients {
+			idle := pc.sessions == 0 && pool.idle_timeout > 0 && now.Sub(pc.last_used) > pool.idle_timeout
+			if pc.sessions == 0 && (pc.broken || idle) {
+				log.Printf("Closing pooled connection to %s", pc.server)
+				go pc.conn.Close()
+				pool.evictions += 1
+			} else {
+				kept = append(kept, pc)
+			}
+		}
+		if len(kept) == 0 {
+			delete(pool.clients, key)
+		} else {
+			pool.clients[key] = kept
+		}
+	}
+}
+
+// Evict stale clients and send keepalives, forever
+func (pool *ClientPool) maintain() {
+	for {
+		time.Sleep(POOL_CHECK_INTERVAL)
+		pool.lock.Lock()
+		pool.evict()
+		var check []*pooledClient
+		now := time.Now()
+		for _, clients := range pool.clients {
+			for _, pc := range clients {
+				if pool.keepalive > 0 && now.Sub(pc.last_checked) >= pool.keepalive {
+					pc.last_checked = now
+					check = append(check, pc)
+				}
+			}
+		}
+		keepalive := pool.keepalive
+		pool.lock.Unlock()
+		for _, pc := range check {
+			if err := pc.conn.SendKeepalive(keepalive); err != nil {
+				log.Printf("Pooled connection to %s failed keepalive: %s", pc.server, err.Error())
+				pool.lock.Lock()
+				pc.broken = true
+				pool.lock.Unlock()
+			}
+		}
+	}
+}
+
+func (pool *ClientPool) Stats() PoolStats {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	stats := PoolStats{
+		Enabled:     pool.enabled,
+		MaxSessions: pool.max_sessions,
+		IdleTimeout: int(pool.idle_timeout.Seconds()),
+		Keepalive:   int(pool.keepalive.Seconds()),
+		Hits:        pool.hits,
+		Misses:      pool.misses,
+		Evictions:   pool.evictions,
+		Connections: []PooledClientStats{},
+	}
+	now := time.Now()
+	for _, clients := range pool.clients {
+		for _, pc := range clients {
+			cs := PooledClientStats{Server: pc.server, Sessions: pc.sessions, Uses: pc.uses, Broken: pc.broken}
+			if pc.sessions == 0 {
+				cs.IdleSeconds = int(now.Sub(pc.last_used).Seconds())
+			}
+			stats.Connections = append(stats.Connections, cs)
+		}
+	}
+	sort.Sort(ClientsByServer(stats.Connections))
+	return stats
+}
+
+func (p *PooledConnection) RunWithWriters(command string, timeout int, sudo bool, stdout io.Writer, stderr io.Writer) error {
+	err := p.client.conn.runCommand(command, timeout, sudo, p.CommandOptions, stdout, stderr)
+	if err != nil {
+		// A command that ran and failed (or was killed) leaves the connection usable. Anything else does not
+		_, exited := err.(*ssh.ExitError)
+		_, killed := err.(*CommandTimeoutError)
+		if !exited && !killed {
+			p.pool.lock.Lock()
+			p.client.broken = true
+			p.pool.lock.Unlock()
+		}
+	}
+	return err
+}
+
+// Return the client to the pool
+func (p *PooledConnection) Close() {
+	pool := p.pool
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	p.client.sessions -= 1
+	p.client.uses += 1
+	p.client.last_used = time.Now()
+	if !pool.enabled {
+		p.client.broken = true
+	}
+	if p.client.broken {
+		pool.evict()
+	}
+}
+
+// Send an OpenSSH keepalive request, failing if there is no reply within timeout
+func (conn *SshConnection) SendKeepalive(timeout time.Duration) error {
+	client := conn.client
+	if client == nil {
+		return errors.New("Not connected")
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errors.New("Keepalive timed out")
+	}
+}
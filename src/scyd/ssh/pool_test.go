@@ -0,0 +1,51 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPoolReuse(t *testing.T) {
+	pool := &ClientPool{clients: make(map[string][]*pooledClient)}
+	pool.Configure(true, 2, 300, 0)
+	opened := 0
+	open := func() (*SshConnection, error) {
+		opened += 1
+		return &SshConnection{}, nil
+	}
+	a, err := pool.Get("key", "foo.bar", open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := pool.Get("key", "foo.bar", open)
+	c, _ := pool.Get("key", "foo.bar", open)
+	if opened != 2 {
+		t.Errorf("Expected 2 clients for 3 sessions with max-sessions 2, got %d", opened)
+	}
+	pool.Get("other", "foo.bar", open)
+	if opened != 3 {
+		t.Errorf("Expected a new client for a different key, got %d", opened)
+	}
+	a.Close()
+	b.Close()
+	c.Close()
+	stats := pool.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("Expected 1 hit and 3 misses, got %d and %d", stats.Hits, stats.Misses)
+	}
+	if len(stats.Connections) != 3 {
+		t.Errorf("Expected 3 pooled clients, got %d", len(stats.Connections))
+	}
+}
+
+func TestPoolOpenError(t *testing.T) {
+	pool := &ClientPool{clients: make(map[string][]*pooledClient)}
+	pool.Configure(true, 1, 300, 0)
+	_, err := pool.Get("key", "foo.bar", func() (*SshConnection, error) { return nil, errors.New("refused") })
+	if err == nil {
+		t.Error("Expected open error")
+	}
+	if len(pool.Stats().Connections) != 0 {
+		t.Error("Failed connection should not be pooled")
+	}
+}
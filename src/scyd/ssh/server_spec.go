@@ -0,0 +1,105 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_PORT = "22"
+
+// A parsed host string: [ssh://][user@]host[:port]. The user is everything
+// before the last @, so user names may themselves contain @. IPv6 literals are
+// written bare (::1) or bracketed ([::1]); a port needs the brackets
+type ServerSpec struct {
+	User string
+	Host string
+	Port string // Empty if not given
+}
+
+func ParseServerSpec(server string) (ServerSpec, error) {
+	spec := ServerSpec{}
+	s := strings.TrimSpace(server)
+	if len(s) >= 6 && strings.ToLower(s[:6]) == "ssh://" {
+		s = strings.TrimSuffix(s[6:], "/")
+		if strings.Contains(s, "/") {
+			return spec, errors.New(fmt.Sprintf("Bad host %q: ssh:// URIs may not have a path", server))
+		}
+	}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		spec.User, s = s[:i], s[i+1:]
+		if spec.User == "" {
+			return spec, errors.New(fmt.Sprintf("Bad host %q: empty user", server))
+		}
+	}
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return spec, errors.New(fmt.Sprintf("Bad host %q: missing ]", server))
+		}
+		spec.Host, s = s[1:end], s[end+1:]
+		if net.ParseIP(strings.SplitN(spec.Host, "%", 2)[0]) == nil {
+			return spec, errors.New(fmt.Sprintf("Bad host %q: %s is not an IP address", server, spec.Host))
+		}
+		if s != "" {
+			if !strings.HasPrefix(s, ":") {
+				return spec, errors.New(fmt.Sprintf("Bad host %q: unexpected %q after ]", server, s))
+			}
+			spec.Port = s[1:]
+		}
+	case strings.Count(s, ":") > 1:
+		// Bare IPv6 literal. There's no telling a port from the address, so there isn't one
+		if net.ParseIP(strings.SplitN(s, "%", 2)[0]) == nil {
+			return spec, errors.New(fmt.Sprintf("Bad host %q: use [address]:port for IPv6 with a port", server))
+		}
+		spec.Host = s
+	case strings.Contains(s, ":"):
+		i := strings.Index(s, ":")
+		spec.Host, spec.Port = s[:i], s[i+1:]
+	default:
+		spec.Host = s
+	}
+	if spec.Host == "" {
+		return spec, errors.New(fmt.Sprintf("Bad host %q: no host name", server))
+	}
+	if strings.ContainsAny(spec.Host, " \t/[]") {
+		return spec, errors.New(fmt.Sprintf("Bad host %q: invalid host name %q", server, spec.Host))
+	}
+	if spec.Port != "" {
+		if port, err := strconv.Atoi(spec.Port); err != nil || port < 1 || port > 65535 {
+			return spec, errors.New(fmt.Sprintf("Bad host %q: invalid port %q", server, spec.Port))
+		}
+	}
+	return spec, nil
+}
+
+// True for the special "local" host, run via exec rather than ssh
+func (spec ServerSpec) IsLocal() bool {
+	return spec.Host == "local"
+}
+
+// Canonical [user@]host[:port] form, which parses back to the same spec
+func (spec ServerSpec) String() string {
+	s := spec.Host
+	if spec.Port != "" {
+		s = net.JoinHostPort(spec.Host, spec.Port)
+	} else if strings.Contains(spec.Host, ":") {
+		s = "[" + spec.Host + "]"
+	}
+	if spec.User != "" {
+		s = spec.User + "@" + s
+	}
+	return s
+}
+
+// host:port to dial, defaulting the port to 22
+func (spec ServerSpec) Addr() string {
+	port := spec.Port
+	if port == "" {
+		port = DEFAULT_PORT
+	}
+	return net.JoinHostPort(spec.Host, port)
+}
@@ -0,0 +1,48 @@
+package ssh
+
+import (
+	"testing"
+)
+
+func TestParseServerSpec(t *testing.T) {
+	good := []struct {
+		in   string
+		want ServerSpec
+		str  string
+	}{
+		{"foo.bar", ServerSpec{"", "foo.bar", ""}, "foo.bar"},
+		{"scylla@foo.bar:2222", ServerSpec{"scylla", "foo.bar", "2222"}, "scylla@foo.bar:2222"},
+		{"jdoe@corp.com@foo.bar", ServerSpec{"jdoe@corp.com", "foo.bar", ""}, "jdoe@corp.com@foo.bar"},
+		{"::1", ServerSpec{"", "::1", ""}, "[::1]"},
+		{"root@[::1]", ServerSpec{"root", "::1", ""}, "root@[::1]"},
+		{"ssh://user@[::1]:2222", ServerSpec{"user", "::1", "2222"}, "user@[::1]:2222"},
+		{"ssh://foo.bar/", ServerSpec{"", "foo.bar", ""}, "foo.bar"},
+		{"[fe80::1%eth0]:22", ServerSpec{"", "fe80::1%eth0", "22"}, "[fe80::1%eth0]:22"},
+		{"scylla@local", ServerSpec{"scylla", "local", ""}, "scylla@local"},
+	}
+	for _, g := range good {
+		spec, err := ParseServerSpec(g.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", g.in, err.Error())
+			continue
+		}
+		if spec != g.want {
+			t.Errorf("%s: expected %+v, got %+v", g.in, g.want, spec)
+		}
+		if spec.String() != g.str {
+			t.Errorf("%s: expected canonical form %s, got %s", g.in, g.str, spec.String())
+		}
+		if again, _ := ParseServerSpec(spec.String()); again != spec {
+			t.Errorf("%s: canonical form does not round trip (%+v)", g.in, again)
+		}
+	}
+	bad := []string{"", "@foo.bar", "foo.bar:ssh", "foo.bar:70000", "[::1", "[foo.bar]:22", "::1:zz:", "ssh://foo.bar/path", "foo bar", "user@"}
+	for _, b := range bad {
+		if spec, err := ParseServerSpec(b); err == nil {
+			t.Errorf("%q: expected an error, got %+v", b, spec)
+		}
+	}
+	if addr := (ServerSpec{Host: "::1"}).Addr(); addr != "[::1]:22" {
+		t.Errorf("Unexpected address %s", addr)
+	}
+}
@@ -2,7 +2,10 @@ package ssh
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"io"
 	"io/ioutil"
 	"log"
@@ -25,13 +28,49 @@ type SshConnection struct {
 	network_conn    net.Conn
 	client_conn     ssh.Conn
 	client          *ssh.Client
-	SudoCommand     string
+	CommandOptions
+	HostKeyCallback ssh.HostKeyCallback // Defaults to ignoring host keys
+	UseAgent        bool                // Authenticate with keys from SSH_AUTH_SOCK
+	AgentForwarding bool                // Forward the agent to remote commands
+	JumpHosts       []JumpHost          // Bastions to tunnel through, in order
+	SshConfig       *SshConfig          // Resolves host aliases. May be nil
+	host_key_err    error
+	shared          bool // Pooled, so sessions may run concurrently
+	jump_addrs      []string
+	jump_configs    []*ssh.ClientConfig
+	jump_clients    []*ssh.Client
+	agent_conn      net.Conn
+	agent           agent.Agent
 }
 
 const NO_TIMEOUT = 0
+const DEFAULT_SUDO_COMMAND = "sudo -i /bin/bash -c"
+
+// Settings for running commands. Set per checkout on pooled connections
+type CommandOptions struct {
+	SudoCommand string // Defaults to DEFAULT_SUDO_COMMAND
+	SudoUser    string // Run sudoed commands as this user rather than root
+	IdleTimeout int    // Seconds a command may go without output
+	Keepalive   int    // Seconds between keepalives while a command runs
+}
 
 var shellescape_re = regexp.MustCompile("([^A-Za-z0-9_\\-.,:\\/@\n])")
 
+// Wrap a command in a sudo command, running it as user if one is given
+func SudoCommandLine(sudo_command string, user string, command string) string {
+	if sudo_command == "" {
+		sudo_command = DEFAULT_SUDO_COMMAND
+	}
+	if user != "" {
+		parts := strings.SplitN(sudo_command, " ", 2)
+		sudo_command = parts[0] + " -u " + Shellescape(user)
+		if len(parts) == 2 {
+			sudo_command += " " + parts[1]
+		}
+	}
+	return sudo_command + " " + Shellescape(command)
+}
+
 func Shellescape(str string) string {
 	return shellescape_re.ReplaceAllString(str, "\\$1")
 }
@@ -51,23 +90,66 @@ func (k *Keychain) Sign(i int, rand io.Reader, data []byte) (signature *ssh.Sign
 	return
 }
 
-func mkSigner(key_filename string) (signer ssh.Signer, err error) {
+func mkSigner(key_filename string, passphrase []byte) (signer ssh.Signer, err error) {
 	f, err := os.Open(key_filename)
 	if err != nil {
 		return
 	}
 	defer f.Close()
 
-	buf, _ := ioutil.ReadAll(f)
-	signer, _ = ssh.ParsePrivateKey(buf)
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return
+	}
+	signer, err = ssh.ParsePrivateKey(buf)
+	if _, encrypted := err.(*ssh.PassphraseMissingError); encrypted {
+		// PEM and OpenSSH-format keys alike
+		if len(passphrase) == 0 {
+			return nil, errors.New(key_filename + " is encrypted, but no passphrase was given")
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(buf, passphrase)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to decrypt %s (%s)", key_filename, err.Error()))
+		}
+		return
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to parse %s (%s)", key_filename, err.Error()))
+	}
 	return
 }
 
+// Load every key file, along with any certificates. Certificates are read from
+// a -cert.pub file next to each key, or from cert_filenames. Unlike MakeKeyring,
+// any failure is an error
+func LoadSigners(key_filenames []string, cert_filenames []string, passphrase []byte) ([]ssh.Signer, error) {
+	signers := []ssh.Signer{}
+	keys := []ssh.PublicKey{}
+	for _, key_filename := range key_filenames {
+		signer, err := mkSigner(key_filename, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, signer.PublicKey())
+		if signer, err = withAdjacentCert(key_filename, signer); err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	if err := attachCerts(signers, keys, cert_filenames); err != nil {
+		return nil, err
+	}
+	return signers, nil
+}
+
 func MakeKeyring(key_filenames []string) ssh.AuthMethod {
 	signers := []ssh.Signer{}
 
 	for _, key_filename := range key_filenames {
-		signer, err := mkSigner(key_filename)
+		signer, err := mkSigner(key_filename, nil)
+		if err == nil {
+			signer, err = withAdjacentCert(key_filename, signer)
+		}
 		if err == nil {
 			signers = append(signers, signer)
 		} else {
@@ -78,48 +160,160 @@ func MakeKeyring(key_filenames []string) ssh.AuthMethod {
 	return ssh.PublicKeys(signers...)
 }
 
-func (conn *SshConnection) Open(server string, auths ssh.AuthMethod, timeout int) error {
-	s := strings.Split(server, "@")
-	conn.server = s[1]
-	// See if we have a port
-	s2 := strings.Split(conn.server, ":")
-	if len(s2) != 2 {
-		conn.server += ":22"
+// Auth methods for a set of key files. Empty if there are no key files, so
+// agent-only connections don't offer an empty keyring. Fails on expired certificates
+func MakeAuths(key_filenames []string, cert_filenames []string, passphrase []byte) ([]ssh.AuthMethod, error) {
+	if len(key_filenames) == 0 {
+		return []ssh.AuthMethod{}, nil
+	}
+	signers, err := LoadSigners(key_filenames, cert_filenames, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckCertificates(signers, time.Now()); err != nil {
+		return nil, err
+	}
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+}
+
+func (conn *SshConnection) Open(server string, auths []ssh.AuthMethod, timeout int) error {
+	user, addr, err := conn.resolveServer(server)
+	if err != nil {
+		return err
+	}
+	conn.server = addr
+	host_key_callback := conn.HostKeyCallback
+	if host_key_callback == nil {
+		host_key_callback = ssh.InsecureIgnoreHostKey()
 	}
-	conn.SudoCommand = "sudo -i /bin/bash -c"
-	conn.config = &ssh.ClientConfig{
-		User:            s[0],
-		Auth:            []ssh.AuthMethod{auths},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	var agent_auth []ssh.AuthMethod
+	if conn.UseAgent || conn.AgentForwarding {
+		conn.closeAgent()
+		if conn.agent_conn, conn.agent, err = dialAgent(); err != nil {
+			return err
+		}
+		if conn.UseAgent {
+			agent_auth = []ssh.AuthMethod{agentAuth(conn.agent)}
+		}
+	}
+	mkConfig := func(user string, auths []ssh.AuthMethod) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User: user,
+			Auth: append(append([]ssh.AuthMethod{}, agent_auth...), auths...),
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				// Hang on to the error -- the handshake error loses its type
+				conn.host_key_err = host_key_callback(hostname, remote, key)
+				return conn.host_key_err
+			},
+		}
+	}
+	conn.config = mkConfig(user, auths)
+	conn.jump_addrs = make([]string, len(conn.JumpHosts))
+	conn.jump_configs = make([]*ssh.ClientConfig, len(conn.JumpHosts))
+	for i, hop := range conn.JumpHosts {
+		hop_user, hop_addr, err := conn.resolveServer(hop.Server)
+		if err != nil {
+			return err
+		}
+		hop_auths := hop.Auths
+		if len(hop_auths) == 0 {
+			hop_auths = auths
+		}
+		conn.jump_addrs[i] = hop_addr
+		conn.jump_configs[i] = mkConfig(hop_user, hop_auths)
 	}
 	conn.original_server = server
 	conn.timeout = timeout
 	return conn.open()
 }
 
-func (conn *SshConnection) open() error {
-	conn.Close()
-	network_conn, err := net.DialTimeout("tcp", conn.server, time.Duration(conn.timeout)*time.Second)
+// User and host:port for a server, resolving host aliases through the ssh
+// config. An explicit port beats the ssh config, which beats the default of 22
+func (conn *SshConnection) resolveServer(server string) (string, string, error) {
+	spec, err := ParseServerSpec(server)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	client_conn, new_chan, req_chan, err := ssh.NewClientConn(network_conn, conn.server, conn.config)
+	hc := conn.SshConfig.Lookup(spec.Host)
+	if hc.HostName != "" {
+		spec.Host = hc.HostName
+	}
+	if spec.Port == "" {
+		spec.Port = hc.Port
+	}
+	return spec.User, spec.Addr(), nil
+}
+
+func (conn *SshConnection) open() error {
+	conn.closeClient()
+	timeout := time.Duration(conn.timeout) * time.Second
+	dial := func(addr string) (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	conn.host_key_err = nil
+	// Tunnel through each jump host in turn. The connect timeout applies per hop
+	for i, hop := range conn.JumpHosts {
+		client, network_conn, err := connectHop(dial, conn.jump_addrs[i], conn.jump_configs[i], timeout)
+		if err != nil {
+			conn.closeClient()
+			if conn.host_key_err != nil {
+				return conn.host_key_err
+			}
+			return errors.New(fmt.Sprintf("Jump host %s: %s", hop.Server, err.Error()))
+		}
+		if i == 0 {
+			conn.network_conn = network_conn // Deadlines only work on the real tcp connection
+		}
+		conn.jump_clients = append(conn.jump_clients, client)
+		dial = func(addr string) (net.Conn, error) {
+			return client.Dial("tcp", addr)
+		}
+	}
+	client, network_conn, err := connectHop(dial, conn.server, conn.config, timeout)
 	if err != nil {
-		network_conn.Close()
+		conn.closeClient()
+		if conn.host_key_err != nil {
+			return conn.host_key_err
+		}
 		return err
 	}
-	client := ssh.NewClient(client_conn, new_chan, req_chan)
-	conn.network_conn = network_conn
-	conn.client_conn = client_conn
+	if conn.AgentForwarding {
+		if err = agent.ForwardToAgent(client, conn.agent); err != nil {
+			client.Close()
+			conn.closeClient()
+			return err
+		}
+	}
+	if conn.network_conn == nil {
+		conn.network_conn = network_conn
+	}
+	conn.client_conn = client.Conn
 	conn.client = client
 	return err
 }
 
 func (conn *SshConnection) Close() {
+	conn.closeClient()
+	conn.closeAgent()
+}
+
+func (conn *SshConnection) closeAgent() {
+	if conn.agent_conn != nil {
+		conn.agent_conn.Close()
+		conn.agent_conn = nil
+		conn.agent = nil
+	}
+}
+
+func (conn *SshConnection) closeClient() {
 	if conn.client_conn != nil {
 		conn.client_conn.Close()
 		conn.client_conn = nil
 	}
+	for i := len(conn.jump_clients) - 1; i >= 0; i-- {
+		conn.jump_clients[i].Close()
+	}
+	conn.jump_clients = nil
 	if conn.network_conn != nil {
 		conn.network_conn.Close()
 		conn.network_conn = nil
@@ -128,7 +322,7 @@ func (conn *SshConnection) Close() {
 
 func (conn *SshConnection) NewSession() (*ssh.Session, error) {
 	sess, err := conn.client.NewSession()
-	if err != nil { // Reopen and try again
+	if err != nil && !conn.shared { // Reopen and try again
 		err = conn.open()
 		if err == nil {
 			sess, err = conn.client.NewSession()
@@ -149,23 +343,37 @@ func (conn *SshConnection) Run(command string, timeout int, sudo bool) (*string,
 }
 
 func (conn *SshConnection) RunWithWriters(command string, timeout int, sudo bool, stdout io.Writer, stderr io.Writer) error {
+	return conn.runCommand(command, timeout, sudo, conn.CommandOptions, stdout, stderr)
+}
+
+// Run a command, killing it if it runs longer than timeout or is silent for
+// opts.IdleTimeout seconds, and sending keepalives every opts.Keepalive seconds
+// to spot a dead peer. Zero disables each check
+func (conn *SshConnection) runCommand(command string, timeout int, sudo bool, opts CommandOptions, stdout io.Writer, stderr io.Writer) error {
 	session, err := conn.NewSession()
 	if err != nil {
 		log.Printf("Unable to open session: %s", err.Error())
 		return err
 	}
 	defer session.Close()
-	if timeout == 0 {
-		conn.network_conn.SetDeadline(time.Time{})
-	} else {
-		conn.network_conn.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	if conn.AgentForwarding {
+		if err = agent.RequestAgentForwarding(session); err != nil {
+			return err
+		}
 	}
 	cmd := command
 	if sudo {
-		cmd = conn.SudoCommand + " " + Shellescape(command)
+		cmd = SudoCommandLine(opts.SudoCommand, opts.SudoUser, command)
 	}
-	session.Stdout = stdout
-	session.Stderr = stderr
-	err = session.Run(cmd)
-	return err
+	activity := NewOutputActivity()
+	session.Stdout = activity.Writer(stdout)
+	session.Stderr = activity.Writer(stderr)
+	if err = session.Start(cmd); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	return conn.watchCommand(session, done, activity, timeout, opts.IdleTimeout, opts.Keepalive)
 }
@@ -0,0 +1,135 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Parsed ssh client config file (~/.ssh/config format). Only Host blocks and
+// the HostName, User, Port, IdentityFile and ProxyJump keywords are used
+type SshConfig struct {
+	blocks []sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  map[string][]string
+}
+
+// Settings for a single host alias. Empty fields were not set
+type HostConfig struct {
+	HostName     string
+	User         string
+	Port         string
+	IdentityFile []string
+	ProxyJump    string
+}
+
+// Load an ssh config file. An empty path yields a nil config, which resolves nothing
+func LoadSshConfig(path string) (*SshConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := ParseSshConfig(f)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err.Error()))
+	}
+	return cfg, nil
+}
+
+func ParseSshConfig(r io.Reader) (*SshConfig, error) {
+	cfg := SshConfig{}
+	// Options before the first Host line apply to every host
+	block := &sshConfigBlock{patterns: []string{"*"}, options: make(map[string][]string)}
+	scanner := bufio.NewScanner(r)
+	line_no := 0
+	for scanner.Scan() {
+		line_no += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool { return c == ' ' || c == '\t' || c == '=' })
+		if len(fields) < 2 {
+			return nil, errors.New(fmt.Sprintf("line %d: missing value", line_no))
+		}
+		keyword := strings.ToLower(fields[0])
+		switch keyword {
+		case "host":
+			cfg.blocks = append(cfg.blocks, *block)
+			block = &sshConfigBlock{patterns: fields[1:], options: make(map[string][]string)}
+		case "match":
+			// Match blocks are not supported; ignore everything up to the next Host
+			cfg.blocks = append(cfg.blocks, *block)
+			block = &sshConfigBlock{options: make(map[string][]string)}
+		default:
+			value := strings.Trim(strings.Join(fields[1:], " "), "\"")
+			block.options[keyword] = append(block.options[keyword], value)
+		}
+	}
+	cfg.blocks = append(cfg.blocks, *block)
+	return &cfg, scanner.Err()
+}
+
+func (b *sshConfigBlock) matches(alias string) bool {
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), alias); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// Settings for an alias. As with ssh, the first value found for each keyword wins
+func (cfg *SshConfig) Lookup(alias string) HostConfig {
+	hc := HostConfig{}
+	if cfg == nil {
+		return hc
+	}
+	first := func(b *sshConfigBlock, keyword string, dest *string) {
+		if values := b.options[keyword]; *dest == "" && len(values) > 0 {
+			*dest = values[0]
+		}
+	}
+	for i, _ := range cfg.blocks {
+		b := &cfg.blocks[i]
+		if !b.matches(alias) {
+			continue
+		}
+		first(b, "hostname", &hc.HostName)
+		first(b, "user", &hc.User)
+		first(b, "port", &hc.Port)
+		first(b, "proxyjump", &hc.ProxyJump)
+		for _, fn := range b.options["identityfile"] {
+			hc.IdentityFile = append(hc.IdentityFile, expandHome(fn))
+		}
+	}
+	hc.HostName = strings.Replace(hc.HostName, "%h", alias, -1)
+	if strings.ToLower(hc.ProxyJump) == "none" {
+		hc.ProxyJump = ""
+	}
+	return hc
+}
+
+func expandHome(fn string) string {
+	if strings.HasPrefix(fn, "~/") {
+		return filepath.Join(os.Getenv("HOME"), fn[2:])
+	}
+	return fn
+}
@@ -0,0 +1,66 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+)
+
+const SSH_CONFIG = `
+# Global settings come first
+User ops
+
+Host web-*
+  HostName %h.prod.example.com
+  Port 2222
+  IdentityFile ~/.ssh/web
+
+Host db1
+  HostName=10.0.0.5
+  User postgres
+  ProxyJump bastion,jump@bastion2:22
+
+Host * !db1
+  Port 22
+  IdentityFile ~/.ssh/id_rsa
+`
+
+func TestSshConfigLookup(t *testing.T) {
+	cfg, err := ParseSshConfig(strings.NewReader(SSH_CONFIG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := cfg.Lookup("web-1")
+	if hc.HostName != "web-1.prod.example.com" || hc.Port != "2222" || hc.User != "ops" {
+		t.Errorf("Bad lookup for web-1: %+v", hc)
+	}
+	if len(hc.IdentityFile) != 2 || !strings.HasSuffix(hc.IdentityFile[0], "/.ssh/web") {
+		t.Errorf("Bad identity files for web-1: %v", hc.IdentityFile)
+	}
+	hc = cfg.Lookup("db1")
+	if hc.HostName != "10.0.0.5" || hc.User != "ops" || hc.Port != "" || hc.ProxyJump != "bastion,jump@bastion2:22" {
+		t.Errorf("Bad lookup for db1: %+v", hc)
+	}
+	if hc := (*SshConfig)(nil).Lookup("web-1"); hc.HostName != "" {
+		t.Errorf("Nil config should resolve nothing: %+v", hc)
+	}
+}
+
+func TestResolveServer(t *testing.T) {
+	cfg, _ := ParseSshConfig(strings.NewReader(SSH_CONFIG))
+	conn := SshConnection{SshConfig: cfg}
+	if user, addr, _ := conn.resolveServer("scylla@web-1"); user != "scylla" || addr != "web-1.prod.example.com:2222" {
+		t.Errorf("Bad resolution for web-1: %s %s", user, addr)
+	}
+	if _, addr, _ := conn.resolveServer("scylla@web-1:22"); addr != "web-1.prod.example.com:22" {
+		t.Errorf("Explicit port should win: %s", addr)
+	}
+	if _, addr, _ := conn.resolveServer("scylla@other"); addr != "other:22" {
+		t.Errorf("Bad resolution for other: %s", addr)
+	}
+	if _, addr, _ := conn.resolveServer("ssh://scylla@[::1]:2222"); addr != "[::1]:2222" {
+		t.Errorf("Bad resolution for IPv6 URI: %s", addr)
+	}
+	if _, _, err := conn.resolveServer("scylla@web-1:ssh"); err == nil {
+		t.Error("Expected an error for a bad port")
+	}
+}
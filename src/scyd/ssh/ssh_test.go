@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"golang.org/x/crypto/ssh"
 	"log"
 	"os"
 	"testing"
@@ -17,7 +18,7 @@ func openConn() (conn SshConnection, err error) {
 	if KEYFILE == "" {
 		KEYFILE = "/home/scylla/.ssh/scylla"
 	}
-	auths := MakeKeyring([]string{KEYFILE})
+	auths := []ssh.AuthMethod{MakeKeyring([]string{KEYFILE})}
 	err = conn.Open(HOST, auths, 5)
 	if err != nil {
 		panic("Unable to connect: " + err.Error())
@@ -63,3 +64,15 @@ func TestCommandTimeout(t *testing.T) {
 	}
 
 }
+
+func TestSudoCommandLine(t *testing.T) {
+	if cmd := SudoCommandLine("", "", "ls /root"); cmd != `sudo -i /bin/bash -c ls\ /root` {
+		t.Errorf("Unexpected default sudo command: %s", cmd)
+	}
+	if cmd := SudoCommandLine("sudo -i /bin/bash -c", "postgres", "psql"); cmd != "sudo -u postgres -i /bin/bash -c psql" {
+		t.Errorf("Unexpected sudo-user command: %s", cmd)
+	}
+	if cmd := SudoCommandLine("doas", "www", "id"); cmd != "doas -u www id" {
+		t.Errorf("Unexpected single word sudo command: %s", cmd)
+	}
+}
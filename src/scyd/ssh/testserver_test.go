@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// In-process ssh server for tests. Commands are run by the exec function,
+// which is handed a channel that is closed if the client kills the command
+type testServer struct {
+	addr            string
+	listener        net.Listener
+	exec            func(cmd string, ch ssh.Channel, conn *ssh.ServerConn, killed chan bool) uint32
+	drop_keepalives bool // Leave keepalives unanswered, like a dead peer
+	lock            sync.Mutex
+	agent_requests  int
+}
+
+func mkTestKey(t *testing.T) (*ecdsa.PrivateKey, ssh.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, signer
+}
+
+// Start a server that accepts the given client key
+func startTestServer(t *testing.T, client_key ssh.PublicKey) *testServer {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(client_key.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	_, host_key := mkTestKey(t)
+	config.AddHostKey(host_key)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{addr: listener.Addr().String(), listener: listener}
+	go func() {
+		for {
+			network_conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(network_conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testServer) agentRequests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.agent_requests
+}
+
+func (s *testServer) serve(network_conn net.Conn, config *ssh.ServerConfig) {
+	conn, chans, reqs, err := ssh.NewServerConn(network_conn, config)
+	if err != nil {
+		network_conn.Close()
+		return
+	}
+	go func() {
+		for req := range reqs {
+			s.lock.Lock()
+			drop := s.drop_keepalives
+			s.lock.Unlock()
+			if req.Type == "keepalive@openssh.com" && drop {
+				continue
+			}
+			req.Reply(req.Type == "keepalive@openssh.com", nil)
+		}
+	}()
+	for new_chan := range chans {
+		if new_chan.ChannelType() != "session" {
+			new_chan.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		ch, requests, err := new_chan.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(conn, ch, requests)
+	}
+}
+
+func (s *testServer) session(conn *ssh.ServerConn, ch ssh.Channel, requests <-chan *ssh.Request) {
+	killed := make(chan bool)
+	var kill sync.Once
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+			go func() {
+				status := s.exec(payload.Command, ch, conn, killed)
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				ch.Close()
+			}()
+		case "signal":
+			kill.Do(func() { close(killed) })
+		case "auth-agent-req@openssh.com":
+			s.lock.Lock()
+			s.agent_requests += 1
+			s.lock.Unlock()
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+	kill.Do(func() { close(killed) })
+}
+
+// Serve an in-memory agent holding the given key on SSH_AUTH_SOCK. Returns a
+// function that puts things back
+func startTestAgent(t *testing.T, signer_key interface{}) func() {
+	dir, err := ioutil.TempDir("", "scylla-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: signer_key}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, c)
+		}
+	}()
+	old_sock := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", sock)
+	return func() {
+		os.Setenv("SSH_AUTH_SOCK", old_sock)
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
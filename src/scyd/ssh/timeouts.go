@@ -0,0 +1,113 @@
+package ssh
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"sync"
+	"time"
+)
+
+// A command killed for running too long or going quiet. The connection is still usable
+type CommandTimeoutError struct {
+	Seconds int
+	Idle    bool // No output, rather than total runtime
+}
+
+func (e *CommandTimeoutError) Error() string {
+	if e.Idle {
+		return fmt.Sprintf("Command killed after %d seconds without output (idle-output-timeout)", e.Seconds)
+	}
+	return fmt.Sprintf("Command killed after exceeding maximum runtime of %d seconds (read-timeout)", e.Seconds)
+}
+
+// The remote end stopped answering keepalives while a command ran
+type ConnectionLostError struct {
+	Server string
+	Reason string
+}
+
+func (e *ConnectionLostError) Error() string {
+	return fmt.Sprintf("Connection to %s lost while command was running: %s", e.Server, e.Reason)
+}
+
+// Time of the last output on either stream. Shared with local runs, which
+// enforce the same idle-output-timeout
+type OutputActivity struct {
+	lock sync.Mutex
+	last time.Time
+}
+
+func NewOutputActivity() *OutputActivity {
+	return &OutputActivity{last: time.Now()}
+}
+
+func (a *OutputActivity) touch() {
+	a.lock.Lock()
+	a.last = time.Now()
+	a.lock.Unlock()
+}
+
+func (a *OutputActivity) Since(now time.Time) time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return now.Sub(a.last)
+}
+
+// Writer that passes output through to w, recording when it was written
+func (a *OutputActivity) Writer(w io.Writer) io.Writer {
+	return &activityWriter{w, a}
+}
+
+type activityWriter struct {
+	w        io.Writer
+	activity *OutputActivity
+}
+
+func (aw *activityWriter) Write(p []byte) (int, error) {
+	aw.activity.touch()
+	return aw.w.Write(p)
+}
+
+// Wait for a started command, enforcing timeouts and keepalives
+func (conn *SshConnection) watchCommand(session *ssh.Session, done chan error, activity *OutputActivity,
+	timeout int, idle_timeout int, keepalive int) error {
+	var max_runtime <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		defer timer.Stop()
+		max_runtime = timer.C
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	keepalive_interval := time.Duration(keepalive) * time.Second
+	keepalive_result := make(chan error, 1)
+	keepalive_pending := false
+	last_keepalive := time.Now()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-max_runtime:
+			session.Signal(ssh.SIGKILL)
+			return &CommandTimeoutError{Seconds: timeout}
+		case err := <-keepalive_result:
+			keepalive_pending = false
+			if err != nil {
+				return &ConnectionLostError{conn.server, err.Error()}
+			}
+		case now := <-ticker.C:
+			if idle_timeout > 0 && activity.Since(now) >= time.Duration(idle_timeout)*time.Second {
+				session.Signal(ssh.SIGKILL)
+				return &CommandTimeoutError{Seconds: idle_timeout, Idle: true}
+			}
+			if keepalive > 0 && !keepalive_pending && now.Sub(last_keepalive) >= keepalive_interval {
+				last_keepalive = now
+				keepalive_pending = true
+				go func() {
+					keepalive_result <- conn.SendKeepalive(keepalive_interval)
+				}()
+			}
+		}
+	}
+}
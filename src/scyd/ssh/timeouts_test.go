@@ -0,0 +1,92 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+func TestActivityWriter(t *testing.T) {
+	activity := NewOutputActivity()
+	activity.last = time.Now().Add(-time.Minute)
+	buf := bytes.NewBufferString("")
+	w := activity.Writer(buf)
+	if activity.Since(time.Now()) < time.Minute {
+		t.Error("Expected a minute of silence")
+	}
+	w.Write([]byte("hello"))
+	if activity.Since(time.Now()) > time.Second {
+		t.Error("Write did not record output")
+	}
+	if buf.String() != "hello" {
+		t.Errorf("Output not passed through: %q", buf.String())
+	}
+}
+
+func TestTimeoutErrors(t *testing.T) {
+	idle := (&CommandTimeoutError{Seconds: 60, Idle: true}).Error()
+	max := (&CommandTimeoutError{Seconds: 60}).Error()
+	lost := (&ConnectionLostError{"foo.bar:22", "Keepalive timed out"}).Error()
+	if idle == max || idle == lost || max == lost {
+		t.Errorf("Expected distinct errors, got %q, %q and %q", idle, max, lost)
+	}
+}
+
+func TestWatchCommand(t *testing.T) {
+	_, signer := mkTestKey(t)
+	server := startTestServer(t, signer.PublicKey())
+	defer server.Close()
+	server.exec = func(cmd string, ch ssh.Channel, conn *ssh.ServerConn, killed chan bool) uint32 {
+		switch cmd {
+		case "chatty": // Output every 200ms until killed
+			for {
+				select {
+				case <-killed:
+					return 137
+				case <-time.After(200 * time.Millisecond):
+					fmt.Fprintln(ch, "tick")
+				}
+			}
+		case "silent":
+			fmt.Fprintln(ch, "started")
+			<-killed
+			return 137
+		}
+		fmt.Fprint(ch, "done")
+		return 0
+	}
+	conn := SshConnection{}
+	if err := conn.Open("scylla@"+server.addr, []ssh.AuthMethod{ssh.PublicKeys(signer)}, 5); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	run := func(cmd string, timeout int, opts CommandOptions) error {
+		var stdout, stderr bytes.Buffer
+		return conn.runCommand(cmd, timeout, false, opts, &stdout, &stderr)
+	}
+
+	if err := run("quick", 5, CommandOptions{IdleTimeout: 5, Keepalive: 1}); err != nil {
+		t.Errorf("Expected a quick command to succeed, got %v", err)
+	}
+	err := run("chatty", 2, CommandOptions{IdleTimeout: 1})
+	if te, ok := err.(*CommandTimeoutError); !ok || te.Idle || te.Seconds != 2 {
+		t.Errorf("Expected the maximum runtime to be exceeded, got %v", err)
+	}
+	err = run("silent", 0, CommandOptions{IdleTimeout: 1})
+	if te, ok := err.(*CommandTimeoutError); !ok || !te.Idle {
+		t.Errorf("Expected an idle-output timeout, got %v", err)
+	}
+	if err := run("quick", 5, CommandOptions{}); err != nil {
+		t.Errorf("Connection should be usable after a killed command, got %v", err)
+	}
+
+	server.lock.Lock()
+	server.drop_keepalives = true
+	server.lock.Unlock()
+	err = run("silent", 0, CommandOptions{Keepalive: 1})
+	if _, ok := err.(*ConnectionLostError); !ok {
+		t.Errorf("Expected unanswered keepalives to lose the connection, got %v", err)
+	}
+}
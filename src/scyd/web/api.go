@@ -3,19 +3,93 @@ package web
 import (
 	"encoding/json"
 	"github.com/martini-contrib/render"
+	"io/ioutil"
 	"net/http"
+	"scyd/config"
 	"scyd/scheduler"
+	"strconv"
 )
 
 func renderJobInfoJson(ctx *Context, parts []string, req *http.Request, r render.Render) {
 	code, resp := getJobInfo(ctx, parts, req, r)
-	r.JSON(code, resp)
+	renderRedactedJson(r, code, resp)
+}
+
+func renderConnectionsJson(ctx *Context, r render.Render) {
+	renderRedactedJson(r, 200, getConnectionInfo(ctx))
+}
+
+// Result of checking the config file on disk
+type ConfigTestResult struct {
+	Valid    bool
+	Problems []config.Problem
+}
+
+func renderConfigTestJson(ctx *Context, r render.Render) {
+	err := validateConfig(*ctx)
+	result := ConfigTestResult{Valid: err == nil, Problems: config.Problems(err)}
+	if err != nil {
+		renderRedactedJson(r, 400, result)
+	} else {
+		renderRedactedJson(r, 200, result)
+	}
+}
+
+// Reload the config, or with ?dry_run=true just report what would change
+func renderReloadJson(ctx *Context, req *http.Request, r render.Render) {
+	dry_run, _ := strconv.ParseBool(req.URL.Query().Get("dry_run"))
+	result := loadConfig(*ctx, dry_run, "api")
+	if len(result.Problems) > 0 {
+		renderRedactedJson(r, 400, result)
+	} else {
+		renderRedactedJson(r, 200, result)
+	}
+}
+
+// Create, replace or delete an API-defined job
+func changeJob(ctx *Context, name string, req *http.Request, r render.Render) {
+	var spec []byte
+	if req.Method != "DELETE" {
+		var err error
+		if spec, err = ioutil.ReadAll(req.Body); err != nil {
+			r.JSON(400, err.Error())
+			return
+		}
+	}
+	resp_chan := make(chan scheduler.StatusResponse)
+	ctx.ReqChan <- scheduler.ChangeJobRequest{Name: name, Method: req.Method, Spec: spec, Chan: resp_chan}
+	result := (<-resp_chan).(*scheduler.ChangeJobResult)
+	renderRedactedJson(r, result.Code, result)
+}
+
+func renderPoolJson(ctx *Context, name string, r render.Render) {
+	resp := getPoolInfo(ctx, name)
+	if msg, found := resp.(string); found {
+		r.JSON(404, msg)
+		return
+	}
+	renderRedactedJson(r, 200, resp)
+}
+
+func renderLocksJson(ctx *Context, r render.Render) {
+	renderRedactedJson(r, 200, getLockInfo(ctx))
 }
 
 func updatePool(ctx *Context, pool_name string, req *http.Request, r render.Render) {
+	if !config.ValidName(pool_name) {
+		r.JSON(400, "Invalid pool name: "+pool_name)
+		return
+	}
 	var hosts []string
 	decoder := json.NewDecoder(req.Body)
 	err := decoder.Decode(&hosts)
+	for i := 0; err == nil && i < len(hosts); i++ {
+		var host string
+		var tags map[string]string
+		if host, tags, err = config.ParsePoolHost(hosts[i]); err == nil {
+			hosts[i] = config.PoolHostEntry(host, tags)
+		}
+	}
 	if err != nil {
 		r.JSON(400, err.Error())
 	} else {
@@ -1,9 +1,13 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/martini-contrib/render"
 	"html/template"
+	"scyd/config"
 	"scyd/scheduler"
+	"scyd/ssh"
 	"time"
 )
 
@@ -16,6 +20,7 @@ var status_buttons = []string{
 	"<button class=\"btn btn-status btn-small btn-danger\">failed</button>",
 	"<button class=\"btn btn-status btn-small btn-danger\">cancelled</button>",
 	"<button class=\"btn btn-status btn-small btn-warning\">dropped</button>",
+	"<button class=\"btn btn-status btn-small btn-info\">queued</button>",
 }
 
 const BTN_UNKNOWN = "<button class==\"btn btn-status btn-small btn-warning\">unknown</button>"
@@ -31,7 +36,7 @@ func (h Helpers) DisplayBool(val bool) string {
 }
 
 func (h Helpers) DisplayRunStatusButton(status scheduler.RunStatus) template.HTML {
-	if status < scheduler.None || status > scheduler.Abandoned {
+	if status < scheduler.None || status > scheduler.Queued {
 		return template.HTML(BTN_UNKNOWN)
 	}
 	return template.HTML(status_buttons[status])
@@ -75,3 +80,45 @@ func (h Helpers) DisplayDuration(from time.Time, to time.Time) string {
 	tm += fmt.Sprintf(" %dm", minutes)
 	return tm
 }
+
+func (h Helpers) Redact(value string) string {
+	return config.Redact(value)
+}
+
+// Render JSON with any config secrets swapped back for their references
+func renderRedactedJson(r render.Render, code int, v interface{}) {
+	data, err := config.RedactedJson(v)
+	if err != nil {
+		r.JSON(500, err.Error())
+		return
+	}
+	r.JSON(code, json.RawMessage(data))
+}
+
+func getConnectionInfo(ctx *Context) *ssh.PoolStats {
+	resp_chan := make(chan scheduler.StatusResponse)
+	ctx.ReqChan <- scheduler.ConnectionStatusRequest{Chan: resp_chan}
+	resp := <-resp_chan
+	return resp.(*ssh.PoolStats)
+}
+
+func getConfigStatus(ctx *Context) *scheduler.ConfigStatus {
+	resp_chan := make(chan scheduler.StatusResponse)
+	ctx.ReqChan <- scheduler.ConfigStatusRequest{Chan: resp_chan}
+	resp := <-resp_chan
+	return resp.(*scheduler.ConfigStatus)
+}
+
+// A pool's *scheduler.PoolReport, or every pool's with an empty name
+func getPoolInfo(ctx *Context, name string) scheduler.StatusResponse {
+	resp_chan := make(chan scheduler.StatusResponse)
+	ctx.ReqChan <- scheduler.PoolStatusRequest{Name: name, Chan: resp_chan}
+	return <-resp_chan
+}
+
+func getLockInfo(ctx *Context) *[]scheduler.LockReport {
+	resp_chan := make(chan scheduler.StatusResponse)
+	ctx.ReqChan <- scheduler.LockStatusRequest{Chan: resp_chan}
+	resp := <-resp_chan
+	return resp.(*[]scheduler.LockReport)
+}
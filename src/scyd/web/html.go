@@ -11,15 +11,30 @@ func renderJobListHtml(ctx *Context, req *http.Request, r render.Render) {
 	code, resp := getJobInfo(ctx, []string{}, req, r)
 	joblist := resp.(*[]scheduler.JobReport)
 	dot := struct {
-		Jobs    *[]scheduler.JobReport
-		Helpers Helpers
+		Jobs         *[]scheduler.JobReport
+		ConfigStatus *scheduler.ConfigStatus
+		Pools        *[]scheduler.PoolReport
+		Helpers      Helpers
 	}{
 		joblist,
+		getConfigStatus(ctx),
+		getPoolInfo(ctx, "").(*[]scheduler.PoolReport),
 		Helpers{},
 	}
 	r.HTML(code, "jobs", dot)
 }
 
+func renderLocksHtml(ctx *Context, r render.Render) {
+	dot := struct {
+		Locks   *[]scheduler.LockReport
+		Helpers Helpers
+	}{
+		getLockInfo(ctx),
+		Helpers{},
+	}
+	r.HTML(200, "locks", dot)
+}
+
 func renderJobDetailHtml(name string, ctx *Context, req *http.Request, r render.Render) {
 	code, resp := getJobInfo(ctx, []string{name}, req, r)
 	if code != 200 {
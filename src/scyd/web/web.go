@@ -20,13 +20,13 @@ type Context struct {
 	Config  config.Config
 }
 
-func loadConfig(ctx Context) (*config.Config, error) {
-	cfg, err := config.New(ctx.CfgPath)
-	if err != nil {
-		return nil, err
-	}
-	ctx.ReqChan <- scheduler.LoadConfigRequest(ctx.CfgPath)
-	return cfg, nil
+// Have the scheduler load (or, with dry_run, just compare) the config file,
+// waiting for the result
+func loadConfig(ctx Context, dry_run bool, source string) *scheduler.ReloadResult {
+	resp_chan := make(chan scheduler.StatusResponse)
+	ctx.ReqChan <- scheduler.LoadConfigRequest{Path: ctx.CfgPath, DryRun: dry_run, Source: source, Chan: resp_chan}
+	resp := <-resp_chan
+	return resp.(*scheduler.ReloadResult)
 }
 
 func validateConfig(ctx Context) (err error) {
@@ -54,7 +54,7 @@ func getJobOutput(jobname, jobid, host, command_id, fn string, res http.Response
 }
 
 func Run(ctx *Context) {
-	loadConfig(*ctx) // Force a load on startup
+	loadConfig(*ctx, false, "startup") // Force a load on startup
 	logger := log.New(os.Stdout, "[web] ", log.Ldate|log.Ltime)
 	server := martini.Classic()
 	server.Map(logger)
@@ -69,6 +69,9 @@ func Run(ctx *Context) {
 	server.Get("/jobs", func(req *http.Request, r render.Render) {
 		renderJobListHtml(ctx, req, r)
 	})
+	server.Get("/locks", func(r render.Render) {
+		renderLocksHtml(ctx, r)
+	})
 	server.Get("/jobs/:name", func(params martini.Params, req *http.Request, r render.Render) {
 		renderJobDetailHtml(params["name"], ctx, req, r)
 	})
@@ -77,12 +80,7 @@ func Run(ctx *Context) {
 	})
 
 	server.Put("/api/v1/reload", func(req *http.Request, r render.Render) {
-		_, err := loadConfig(*ctx)
-		if err != nil {
-			r.JSON(400, err.Error())
-		} else {
-			r.JSON(200, "ok")
-		}
+		renderReloadJson(ctx, req, r)
 	})
 	server.Put("/api/v1/run/:job", func(params martini.Params, req *http.Request, r render.Render) {
 		ctx.ReqChan <- scheduler.RunJobRequest(params["job"])
@@ -93,12 +91,27 @@ func Run(ctx *Context) {
 		ctx.ReqChan <- change_run_status_req
 	})
 
+	server.Get("/api/v1/pool/:pool", func(params martini.Params, r render.Render) {
+		renderPoolJson(ctx, params["pool"], r)
+	})
 	server.Put("/api/v1/pool/:pool", func(params martini.Params, req *http.Request, r render.Render) {
 		updatePool(ctx, params["pool"], req, r)
 	})
 
-	server.Get("/api/v1/test", func(req *http.Request, r render.Render) {
-		validateConfig(*ctx)
+	server.Put("/api/v1/test", func(r render.Render) {
+		renderConfigTestJson(ctx, r)
+	})
+	server.Get("/api/v1/test", func(r render.Render) {
+		renderConfigTestJson(ctx, r)
+	})
+	server.Get("/api/v1/config", func(r render.Render) {
+		renderRedactedJson(r, 200, getConfigStatus(ctx))
+	})
+	server.Get("/api/v1/connections", func(r render.Render) {
+		renderConnectionsJson(ctx, r)
+	})
+	server.Get("/api/v1/locks", func(r render.Render) {
+		renderLocksJson(ctx, r)
 	})
 	server.Get("/api/v1/jobs", func(req *http.Request, r render.Render) {
 		renderJobInfoJson(ctx, []string{}, req, r)
@@ -106,6 +119,15 @@ func Run(ctx *Context) {
 	server.Get("/api/v1/jobs/:name", func(params martini.Params, req *http.Request, r render.Render) {
 		renderJobInfoJson(ctx, []string{params["name"]}, req, r)
 	})
+	server.Post("/api/v1/jobs/:name", func(params martini.Params, req *http.Request, r render.Render) {
+		changeJob(ctx, params["name"], req, r)
+	})
+	server.Put("/api/v1/jobs/:name", func(params martini.Params, req *http.Request, r render.Render) {
+		changeJob(ctx, params["name"], req, r)
+	})
+	server.Delete("/api/v1/jobs/:name", func(params martini.Params, req *http.Request, r render.Render) {
+		changeJob(ctx, params["name"], req, r)
+	})
 	server.Get("/api/v1/jobs/:name/:id", func(params martini.Params, req *http.Request, r render.Render) {
 		renderJobInfoJson(ctx, []string{params["name"], params["id"]}, req, r)
 	})